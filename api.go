@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseListenAddr splits the address of the control API into a network
+// and an address for net.Listen. Addresses prefixed with "unix:" or
+// containing a slash are unix socket paths, and the rest must be localhost
+// TCP addresses like "localhost:8123".
+func parseListenAddr(addr string) (string, string, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:"), nil
+	}
+	if strings.Contains(addr, "/") {
+		return "unix", addr, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to parse listen address '%s': %s", addr, err)
+	}
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", "", fmt.Errorf("listen address '%s' must be a unix socket or on localhost", addr)
+	}
+	return "tcp", addr, nil
+}
+
+// apiHeader is the header every control API request must have. Browsers
+// don't let web pages send it to other sites without asking them first,
+// so that the pages open in them can't control justrun.
+const apiHeader = "X-Justrun"
+
+// apiEvent is a filesystem event as the control API reports it. Lost
+// events have no path and the op "LOST".
+type apiEvent struct {
+	Rule string    `json:"rule,omitempty"`
+	Time time.Time `json:"time"`
+	Path string    `json:"path"`
+	Op   string    `json:"op"`
+}
+
+func newAPIEvent(name string, ev event) apiEvent {
+	op := ev.Event.Op.String()
+	if ev.lost() {
+		op = "LOST"
+	}
+	return apiEvent{Rule: name, Time: ev.Time, Path: ev.Event.Name, Op: op}
+}
+
+// ruleStatus is the state of a rule as the control API reports it.
+type ruleStatus struct {
+	Name string `json:"name,omitempty"`
+	cmdStatus
+	Paused     bool       `json:"paused"`
+	Paths      []string   `json:"paths"`
+	LastEvents []apiEvent `json:"last_events"`
+}
+
+// eventFeed sends the events published to it to its subscribers.
+type eventFeed struct {
+	mu   sync.Mutex
+	subs map[chan apiEvent]bool
+}
+
+func newEventFeed() *eventFeed {
+	return &eventFeed{subs: make(map[chan apiEvent]bool)}
+}
+
+// publish sends the event for the named rule to the subscribers. It drops
+// the event for subscribers that have fallen behind rather than hold up
+// the rule.
+func (f *eventFeed) publish(name string, ev event) {
+	aev := newAPIEvent(name, ev)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- aev:
+		default:
+		}
+	}
+}
+
+func (f *eventFeed) subscribe() chan apiEvent {
+	ch := make(chan apiEvent, 100)
+	f.mu.Lock()
+	f.subs[ch] = true
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *eventFeed) unsubscribe(ch chan apiEvent) {
+	f.mu.Lock()
+	delete(f.subs, ch)
+	f.mu.Unlock()
+}
+
+// apiServer is the HTTP control API that lets other programs inspect and
+// control a running justrun.
+type apiServer struct {
+	rules []*rule
+	w     *watcher
+	feed  *eventFeed
+	ln    net.Listener
+	log   *slog.Logger
+}
+
+// listenAPI starts listening for control API requests on addr. It sends
+// the events of the rules to the API's event stream.
+func listenAPI(addr string, rules []*rule, w *watcher, log *slog.Logger) (*apiServer, error) {
+	network, address, err := parseListenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on '%s': %s", addr, err)
+	}
+	a := &apiServer{rules: rules, w: w, feed: newEventFeed(), ln: ln, log: log}
+	for _, r := range rules {
+		r.feed = a.feed
+	}
+	return a, nil
+}
+
+// removeStaleSocket removes the unix socket at path if nothing is
+// listening on it, like after justrun was killed.
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// serve handles requests until the API is closed.
+func (a *apiServer) serve() {
+	err := http.Serve(a.ln, a.handler())
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		a.log.Warn("control API stopped", "err", err)
+	}
+}
+
+func (a *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", a.handleStatus)
+	mux.HandleFunc("/rerun", a.handleRerun)
+	mux.HandleFunc("/pause", a.handlePause)
+	mux.HandleFunc("/resume", a.handlePause)
+	mux.HandleFunc("/events", a.handleEvents)
+	mux.HandleFunc("/paths", a.handlePaths)
+	return a.guard(mux)
+}
+
+// guard only passes on the requests h should trust to h. Requests from web
+// pages have an Origin header or lack apiHeader, and ones made through
+// DNS rebinding name another host than localhost.
+func (a *apiServer) guard(h http.Handler) http.Handler {
+	unix := a.ln != nil && a.ln.Addr().Network() == "unix"
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Header.Get("Origin") != "":
+			http.Error(rw, "requests from web pages are not allowed", http.StatusForbidden)
+		case req.Header.Get(apiHeader) == "":
+			http.Error(rw, "requests must have the "+apiHeader+" header", http.StatusForbidden)
+		case !unix && !isLoopbackHost(req.Host):
+			http.Error(rw, "requests must be for localhost", http.StatusForbidden)
+		default:
+			h.ServeHTTP(rw, req)
+		}
+	})
+}
+
+// isLoopbackHost returns true if host, with or without a port, is
+// localhost or a loopback address.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// Close stops the API and removes its unix socket. It does nothing if a is
+// nil.
+func (a *apiServer) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.ln.Close()
+}
+
+// rulesFor returns the rule named by the request's "rule" parameter, or
+// all of the rules if it has none.
+func (a *apiServer) rulesFor(req *http.Request) ([]*rule, error) {
+	name := req.FormValue("rule")
+	if name == "" {
+		return a.rules, nil
+	}
+	for _, r := range a.rules {
+		if r.name == name {
+			return []*rule{r}, nil
+		}
+	}
+	return nil, fmt.Errorf("no rule named '%s'", name)
+}
+
+func (a *apiServer) handleStatus(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(rw, "status must be a GET", http.StatusMethodNotAllowed)
+		return
+	}
+	rules, err := a.rulesFor(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	sts := make([]ruleStatus, 0, len(rules))
+	for _, r := range rules {
+		st := ruleStatus{Name: r.name, cmdStatus: r.cmd.Status()}
+		r.mu.Lock()
+		st.Paused = r.paused
+		st.Paths = r.wc.inputPaths
+		st.LastEvents = make([]apiEvent, len(r.lastEvents))
+		for i, ev := range r.lastEvents {
+			st.LastEvents[i] = newAPIEvent(r.name, ev)
+		}
+		r.mu.Unlock()
+		sts = append(sts, st)
+	}
+	a.writeJSON(rw, sts)
+}
+
+func (a *apiServer) handleRerun(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(rw, "rerun must be a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	rules, err := a.rulesFor(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	for _, r := range rules {
+		r.rerun()
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// handlePause handles both /pause and /resume.
+func (a *apiServer) handlePause(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(rw, "pause and resume must be POSTs", http.StatusMethodNotAllowed)
+		return
+	}
+	rules, err := a.rulesFor(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	for _, r := range rules {
+		r.setPaused(req.URL.Path == "/pause")
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// handleEvents streams the events of the rules as they arrive, one JSON
+// object per line, until the client goes away.
+func (a *apiServer) handleEvents(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(rw, "events must be a GET", http.StatusMethodNotAllowed)
+		return
+	}
+	rules, err := a.rulesFor(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	wanted := make(map[string]bool)
+	for _, r := range rules {
+		wanted[r.name] = true
+	}
+	ch := a.feed.subscribe()
+	defer a.feed.unsubscribe(ch)
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+	flusher, _ := rw.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(rw)
+	for {
+		select {
+		case ev := <-ch:
+			if !wanted[ev.Rule] {
+				continue
+			}
+			err := enc.Encode(ev)
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// handlePaths adds (with a POST) or removes (with a DELETE) the "path"
+// parameters to or from the paths a rule watches. Relative paths are
+// relative to justrun's working directory.
+func (a *apiServer) handlePaths(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" && req.Method != "DELETE" {
+		http.Error(rw, "paths must be a POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	rules, err := a.rulesFor(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if len(rules) != 1 {
+		http.Error(rw, "a rule must be given when there are more than one", http.StatusBadRequest)
+		return
+	}
+	r := rules[0]
+	err = req.ParseForm()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	given := req.Form["path"]
+	if len(given) == 0 {
+		http.Error(rw, "no paths given", http.StatusBadRequest)
+		return
+	}
+	err = r.editPaths(a.w, func(paths []string) []string {
+		if req.Method == "POST" {
+			return append(paths, given...)
+		}
+		return withoutPaths(paths, given)
+	})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// withoutPaths returns the paths that are not the same path as one of the
+// removed ones.
+func withoutPaths(paths, removed []string) []string {
+	gone := make(map[string]bool)
+	for _, p := range removed {
+		gone[p] = true
+		if abs, err := filepath.Abs(p); err == nil {
+			gone[abs] = true
+		}
+	}
+	var left []string
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if gone[p] || (err == nil && gone[abs]) {
+			continue
+		}
+		left = append(left, p)
+	}
+	return left
+}
+
+func (a *apiServer) writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(rw).Encode(v)
+	if err != nil {
+		a.log.Warn("unable to write control API response", "err", err)
+	}
+}
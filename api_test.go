@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+		ok      bool
+	}{
+		{"unix:justrun.sock", "unix", "justrun.sock", true},
+		{"/tmp/justrun.sock", "unix", "/tmp/justrun.sock", true},
+		{"localhost:8123", "tcp", "localhost:8123", true},
+		{"127.0.0.1:8123", "tcp", "127.0.0.1:8123", true},
+		{"[::1]:8123", "tcp", "[::1]:8123", true},
+		{":8123", "", "", false},
+		{"0.0.0.0:8123", "", "", false},
+		{"example.com:8123", "", "", false},
+		{"justrun.sock", "", "", false},
+	}
+	for _, tc := range tests {
+		network, address, err := parseListenAddr(tc.addr)
+		if (err == nil) != tc.ok {
+			t.Errorf("%#v: want ok %t, got error %v", tc.addr, tc.ok, err)
+			continue
+		}
+		if network != tc.network || address != tc.address {
+			t.Errorf("%#v: want %s %#v, got %s %#v", tc.addr, tc.network, tc.address, network, address)
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.Mkdir(a, 0777)
+	os.Mkdir(b, 0777)
+	r, err := newRule(&ruleConfig{Command: "exit 3"}, watchConfig{inputPaths: []string{a}}, slog.Default(), nil)
+	if err != nil {
+		t.Fatalf("newRule: %s", err)
+	}
+	w, err := newWatcher(watcherOptions{})
+	if err != nil {
+		t.Fatalf("newWatcher: %s", err)
+	}
+	defer w.Close()
+	err = w.addSet(r.wc, r.cmdCh)
+	if err != nil {
+		t.Fatalf("addSet: %s", err)
+	}
+	api := &apiServer{rules: []*rule{r}, w: w, feed: newEventFeed(), log: slog.Default()}
+	r.feed = api.feed
+	w.start()
+	go r.run()
+	defer r.cmd.Terminate()
+	srv := httptest.NewServer(api.handler())
+	defer srv.Close()
+
+	post := func(method, path string, params url.Values) {
+		req, _ := http.NewRequest(method, srv.URL+path+"?"+params.Encode(), nil)
+		req.Header.Set(apiHeader, "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%s %s: want status 204, got %d", method, path, resp.StatusCode)
+		}
+	}
+	status := func() ruleStatus {
+		req, _ := http.NewRequest("GET", srv.URL+"/status", nil)
+		req.Header.Set(apiHeader, "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("status: %s", err)
+		}
+		defer resp.Body.Close()
+		var sts []ruleStatus
+		err = json.NewDecoder(resp.Body).Decode(&sts)
+		if err != nil || len(sts) != 1 {
+			t.Fatalf("status: want one rule, got %v (%v)", sts, err)
+		}
+		return sts[0]
+	}
+
+	post("POST", "/paths", url.Values{"path": {b}})
+	post("DELETE", "/paths", url.Values{"path": {a}})
+	post("POST", "/pause", nil)
+	st := status()
+	if strings.Join(st.Paths, ",") != b {
+		t.Errorf("want paths [%s], got %v", b, st.Paths)
+	}
+	if !st.Paused {
+		t.Errorf("want the rule paused")
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/events", nil)
+	req.Header.Set(apiHeader, "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("events: %s", err)
+	}
+	defer resp.Body.Close()
+	evCh := make(chan apiEvent, 10)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			var ev apiEvent
+			if json.Unmarshal(sc.Bytes(), &ev) == nil {
+				evCh <- ev
+			}
+		}
+	}()
+	os.WriteFile(filepath.Join(a, "ignored"), nil, 0666)
+	os.WriteFile(filepath.Join(b, "seen"), nil, 0666)
+	select {
+	case ev := <-evCh:
+		if ev.Path != filepath.Join(b, "seen") {
+			t.Errorf("want an event for %s, got one for %s", filepath.Join(b, "seen"), ev.Path)
+		}
+	case <-time.After(waitForMsg):
+		t.Fatalf("no event streamed")
+	}
+
+	post("POST", "/rerun", nil)
+	deadline := time.Now().Add(waitForMsg)
+	for {
+		st = status()
+		if st.Generation == 2 && st.LastExitCode != nil && *st.LastExitCode == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want a second run that exited with 3, got %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(st.LastEvents) == 0 {
+		t.Errorf("want the events from while paused to be given to the rerun")
+	}
+}
+
+func TestAPIGuard(t *testing.T) {
+	api := &apiServer{feed: newEventFeed(), log: slog.Default()}
+	srv := httptest.NewServer(api.handler())
+	defer srv.Close()
+
+	for i, c := range []struct {
+		header map[string]string
+		host   string
+		want   int
+	}{
+		{header: map[string]string{apiHeader: "1"}, want: http.StatusNoContent},
+		{header: map[string]string{"Origin": "http://evil.example"}, want: http.StatusForbidden},
+		{header: map[string]string{apiHeader: "1", "Origin": "http://evil.example"}, want: http.StatusForbidden},
+		{header: map[string]string{apiHeader: "1"}, host: "evil.example", want: http.StatusForbidden},
+		{header: map[string]string{apiHeader: "1"}, host: "localhost:8123", want: http.StatusNoContent},
+	} {
+		req, _ := http.NewRequest("POST", srv.URL+"/rerun", strings.NewReader("rule="))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+		if c.host != "" {
+			req.Host = c.host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("%d: want status %d, got %d", i, c.want, resp.StatusCode)
+		}
+	}
+}
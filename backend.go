@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/fsnotify/fsnotify"
+)
+
+// backend finds out about changes to the paths added to it, and sends
+// them as fsnotify events. The events for a directory are the ones for the
+// files and directories directly inside of it, like with inotify.
+type backend interface {
+	Add(path string) error
+	Remove(path string) error
+	// Events and Errors are closed when the backend is.
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// notifyBackend is told about changes by the OS, through fsnotify.
+type notifyBackend struct {
+	w *fsnotify.Watcher
+}
+
+// newNotifyBackend creates a notifyBackend that buffers up to buffer
+// events that justrun hasn't handled yet.
+func newNotifyBackend(buffer uint) (*notifyBackend, error) {
+	w, err := fsnotify.NewBufferedWatcher(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &notifyBackend{w: w}, nil
+}
+
+func (nb *notifyBackend) Add(path string) error         { return nb.w.Add(path) }
+func (nb *notifyBackend) Remove(path string) error      { return nb.w.Remove(path) }
+func (nb *notifyBackend) Events() <-chan fsnotify.Event { return nb.w.Events }
+func (nb *notifyBackend) Errors() <-chan error          { return nb.w.Errors }
+func (nb *notifyBackend) Close() error                  { return nb.w.Close() }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	colorGreen  = "\033[32m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// runStats are the running totals of the runs of a command that exited on
+// their own.
+type runStats struct {
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+	// total is the wall-clock time taken by those runs.
+	total time.Duration
+}
+
+// add counts a run that exited on its own.
+func (rs *runStats) add(passed bool, wall time.Duration) {
+	if passed {
+		rs.Passed++
+	} else {
+		rs.Failed++
+	}
+	rs.total += wall
+}
+
+func (rs *runStats) String() string {
+	n := rs.Passed + rs.Failed
+	if n == 0 {
+		return "no finished runs"
+	}
+	avg := (rs.total / time.Duration(n)).Round(time.Millisecond)
+	return fmt.Sprintf("%d passed, %d failed, %s average", rs.Passed, rs.Failed, avg)
+}
+
+// runBanner describes how a run of a command ended: its exit code or the
+// signal that killed it, how long it took, and the CPU time it used. Runs
+// that justrun stopped are marked as such. With color, the banner is green
+// for runs that passed, red for ones that failed, and yellow for stopped
+// ones.
+func runBanner(state *os.ProcessState, stopped bool, wall time.Duration, color bool) string {
+	outcome, c := "passed", colorGreen
+	switch {
+	case stopped:
+		outcome, c = "stopped", colorYellow
+	case !state.Success():
+		outcome, c = "failed", colorRed
+	}
+	how := fmt.Sprintf("exit %d", state.ExitCode())
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		how = "signal " + signalName(status.Signal())
+	}
+	s := fmt.Sprintf("=== %s (%s) in %s (user %s, sys %s)", outcome, how,
+		wall.Round(time.Millisecond),
+		state.UserTime().Round(time.Millisecond),
+		state.SystemTime().Round(time.Millisecond))
+	if color {
+		s = c + s + colorReset
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBanner(t *testing.T) {
+	tests := []struct {
+		command string
+		stopped bool
+		want    string
+		color   string
+	}{
+		{"exit 0", false, "=== passed (exit 0) in 1.5s", colorGreen},
+		{"exit 3", false, "=== failed (exit 3) in 1.5s", colorRed},
+		{"kill -KILL $$", false, "=== failed (signal KILL) in 1.5s", colorRed},
+		{"kill -TERM $$", true, "=== stopped (signal TERM) in 1.5s", colorYellow},
+	}
+	for _, tc := range tests {
+		cmd := exec.Command("sh", "-c", tc.command)
+		cmd.Run()
+		got := runBanner(cmd.ProcessState, tc.stopped, 1500*time.Millisecond, false)
+		if !strings.HasPrefix(got, tc.want+" (user ") {
+			t.Errorf("%#v: want a banner starting with %q, got %q", tc.command, tc.want, got)
+		}
+		got = runBanner(cmd.ProcessState, tc.stopped, 1500*time.Millisecond, true)
+		if !strings.HasPrefix(got, tc.color) || !strings.HasSuffix(got, colorReset) {
+			t.Errorf("%#v: want a banner colored with %q, got %q", tc.command, tc.color, got)
+		}
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	var rs runStats
+	if rs.String() != "no finished runs" {
+		t.Errorf("want no finished runs, got %q", rs.String())
+	}
+	rs.add(true, time.Second)
+	rs.add(true, 2*time.Second)
+	rs.add(false, 3*time.Second)
+	want := "2 passed, 1 failed, 2s average"
+	if rs.String() != want {
+		t.Errorf("want %q, got %q", want, rs.String())
+	}
+}
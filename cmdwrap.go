@@ -1,31 +1,48 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type cmdWrapper struct {
 	command string
 	shell   string
-	cmd     *exec.Cmd
+	stdout  io.Writer
+	stderr  io.Writer
+	// env is the command's environment. If nil, it is justrun's.
+	env []string
+	cmd *exec.Cmd
+
+	// sent is the set of signals sent to the command's process group.
+	sent []syscall.Signal
+	// stopped is true once justrun has asked the command to exit.
+	stopped bool
+	// output is the end of what the command wrote to stdout and
+	// stderr.
+	output *tailBuffer
 }
 
 // Start creates a new process with the given bash command, starts it, and
 // sets it as the wrapped command. If exec.Cmd.Start returns an error, the
 // last wrapped cmd will be left in place.
 func (cw *cmdWrapper) Start() error {
-	cmd := exec.Command(*shell, "-c", *command)
+	cmd := exec.Command(cw.shell, "-c", cw.command)
 	// Necessary so that the SIGTERM's in Terminate will traverse down to the
 	// the child processes in the bash command above.
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = cw.stdout
+	cmd.Stderr = cw.stderr
+	cmd.Env = cw.env
 
 	err := cmd.Start()
 	if err != nil {
@@ -35,16 +52,36 @@ func (cw *cmdWrapper) Start() error {
 	return nil
 }
 
-func (cw *cmdWrapper) Terminate() error {
+// Terminate sends the given stop signal to the command's process group.
+func (cw *cmdWrapper) Terminate(sig syscall.Signal) error {
+	cw.stopped = true
+	return cw.Signal(sig)
+}
+
+// Kill sends SIGKILL to the command's process group.
+func (cw *cmdWrapper) Kill() error {
+	cw.stopped = true
+	return cw.Signal(syscall.SIGKILL)
+}
+
+// Signal sends sig to the command's process group.
+func (cw *cmdWrapper) Signal(sig syscall.Signal) error {
 	if cw.cmd == nil {
 		return errors.New("not started")
 	}
-	// The negation here means to kill, not just the parent pid (which
-	// is the bash shell), but also its children. This means that even
-	// long-lived servers can be gently killed (e.g "-c 'go
+	cw.sent = append(cw.sent, sig)
+	// The negation here means to signal, not just the parent pid
+	// (which is the bash shell), but also its children. This means
+	// that even long-lived servers can be gently killed (e.g "-c 'go
 	// build && ./myserver -http=:6000'"). fswatch and other systems
 	// can't do this.
-	return syscall.Kill(-cw.cmd.Process.Pid, syscall.SIGTERM)
+	return syscall.Kill(-cw.cmd.Process.Pid, sig)
+}
+
+// alive returns true if any process in the command's process group is
+// still running.
+func (cw *cmdWrapper) alive() bool {
+	return syscall.Kill(-cw.cmd.Process.Pid, 0) == nil
 }
 
 func (cw *cmdWrapper) Wait() error {
@@ -52,6 +89,14 @@ func (cw *cmdWrapper) Wait() error {
 }
 
 type cmdReloader struct {
+	// name is the name of the rule the command belongs to. If it is
+	// not empty, it prefixes the command's output and the log messages
+	// about it.
+	name string
+	// log is where messages about the command go, and lifecycle where
+	// records of its runs go.
+	log            *slog.Logger
+	lifecycle      *lifecycleLog
 	command        string
 	shell          string
 	cond           *sync.Cond
@@ -61,12 +106,84 @@ type cmdReloader struct {
 	waitForCommand bool
 	preventReloads bool
 	cmd            *cmdWrapper
+
+	// killAfter is how long to wait for the command's process group to
+	// exit after terminating it before killing it with SIGKILL. Zero
+	// means it is never killed.
+	killAfter time.Duration
+
+	// stopSignal is the signal sent to the command's process group to
+	// terminate it.
+	stopSignal syscall.Signal
+
+	// changedFile, if not empty, is the path of a file the paths that
+	// changed since the last run are written to before each run.
+	changedFile string
+	// substitute causes "{}" in the command to be replaced with the
+	// shell-quoted paths that changed since the last run.
+	substitute bool
+
+	// restart says whether the command is started again when it exits
+	// without justrun stopping it.
+	restart restartPolicy
+	// backoff is the time to wait before those restarts. It is reset by
+	// Reload.
+	backoff backoff
+	// restartTimer, if not nil, is the timer for a pending restart.
+	restartTimer *time.Timer
+	// changed is the paths given to the last Reload. Restarts reuse
+	// them.
+	changed []string
+
+	// started is the time the command was last started.
+	started time.Time
+	// lastExit is the state of the last run of the command to exit.
+	lastExit *os.ProcessState
+	// stats counts the runs that exited on their own.
+	stats runStats
+
+	// color causes the banner written after each run to be colored.
+	color bool
+	// summary causes the banner to include the stats.
+	summary bool
+
+	// ready, if not nil, tells when a run of the command is ready.
+	// Without it, runs are ready as soon as they start.
+	ready *readyCheck
+	// readyGen is the generation of the last run to become ready, and
+	// readyAt the time it did.
+	readyGen int
+	readyAt  time.Time
+	// listeners are told as runs of the command reach each runState.
+	listeners []func(runChange)
+}
+
+// cmdStatus describes the command of a cmdReloader.
+type cmdStatus struct {
+	Pid        int        `json:"pid,omitempty"`
+	Running    bool       `json:"running"`
+	Generation int        `json:"generation"`
+	Started    *time.Time `json:"started,omitempty"`
+	// LastExit describes how the last run of the command to exit did,
+	// like "exit status 1".
+	LastExit     string `json:"last_exit,omitempty"`
+	LastExitCode *int   `json:"last_exit_code,omitempty"`
+	// Ready is true if the running command has passed its readiness
+	// check, and ReadyAfter is how long after starting it did.
+	Ready      bool    `json:"ready"`
+	ReadyAfter float64 `json:"ready_after_seconds,omitempty"`
+	runStats
 }
 
+// changedEnv is the environment variable that holds the newline-separated
+// paths that changed since the command's last run.
+const changedEnv = "JUSTRUN_CHANGED"
+
 // Reload stops the currently running process started by a previous Reload (if
-// called) and starts a new one. If Terminate has been previously called, it
-// will do nothing.
-func (cs *cmdReloader) Reload() {
+// called) and starts a new one. The paths that changed since the last Reload
+// are made available to the new process. If Terminate has been previously
+// called, it will do nothing.
+func (cs *cmdReloader) Reload(changed []string) {
 	cs.cond.L.Lock()
 	defer cs.cond.L.Unlock()
 
@@ -76,34 +193,93 @@ func (cs *cmdReloader) Reload() {
 		return
 	}
 
+	cs.cancelRestart()
+	cs.backoff.reset()
 	if cs.cmd != nil {
 		// Unlock is here to allow terminate to take care of that itself.
 		cs.cond.L.Unlock()
-		cs.terminate()
+		cs.terminate(cs.stopSignal)
 		cs.cond.L.Lock()
 		if !cs.waitFinished {
 			panic("previous command run did not complete before it was attempted to be run again")
 		}
 	}
+	cs.changed = changed
+	cs.start()
+}
 
+// start starts the command with the paths in cs.changed. It must be called
+// with cs.cond.L held and the previous run of the command finished.
+func (cs *cmdReloader) start() {
+	cs.cancelRestart()
+	changed := cs.changed
 	cs.waitFinished = false
 	cs.waitErr = nil
 
-	log.Printf("running '%s'\n", cs.command)
+	command := cs.command
+	if cs.substitute {
+		quoted := make([]string, len(changed))
+		for i, p := range changed {
+			quoted[i] = shellQuote(p)
+		}
+		command = strings.ReplaceAll(command, "{}", strings.Join(quoted, " "))
+	}
+	if cs.changedFile != "" {
+		err := writeChangedFile(cs.changedFile, changed)
+		if err != nil {
+			cs.log.Warn("unable to write changed paths", "path", cs.changedFile, "err", err)
+		}
+	}
+
+	cs.log.Info("running command", "command", command)
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if cs.name != "" {
+		stdout = &prefixWriter{w: os.Stdout, prefix: "[" + cs.name + "] "}
+		stderr = &prefixWriter{w: os.Stderr, prefix: "[" + cs.name + "] "}
+	}
+	output := &tailBuffer{max: maxOutputKept}
 	cs.cmd = &cmdWrapper{
-		command: cs.command,
+		command: command,
 		shell:   cs.shell,
+		stdout:  io.MultiWriter(stdout, output),
+		stderr:  io.MultiWriter(stderr, output),
+		env:     append(os.Environ(), changedEnv+"="+strings.Join(changed, "\n")),
+		output:  output,
+	}
+	gen := cs.reloadGen + 1
+	if cs.ready != nil && cs.ready.pattern != nil {
+		cs.cmd.stdout = &readyWriter{
+			w:       cs.cmd.stdout,
+			pattern: cs.ready.pattern,
+			ready:   func() { cs.markReady(gen) },
+		}
 	}
 
 	err := cs.cmd.Start()
 	if err != nil {
-		log.Printf("command failed: %s", err)
+		cs.log.Error("command failed to start", "err", err)
+		cs.cmd = nil
 		return
 	}
 	cs.reloadGen++
+	cs.started = time.Now()
+	cs.lifecycle.emit(lifecycleRecord{
+		Type:       recRunStart,
+		Rule:       cs.name,
+		Pid:        cs.cmd.cmd.Process.Pid,
+		Generation: cs.reloadGen,
+		Changed:    changed,
+	})
+	cs.notify(runChange{state: runStarted, gen: gen, changed: changed})
+	switch {
+	case cs.ready == nil:
+		cs.setReady(gen)
+	case cs.ready.pattern == nil:
+		go cs.awaitReady(gen)
+	}
 
-	go func(cmd *exec.Cmd, cmdGen int) {
-		err := cmd.Wait()
+	go func(cw *cmdWrapper, cmdGen int) {
+		err := cw.Wait()
 		cs.cond.L.Lock()
 		defer cs.cond.L.Unlock()
 		if cs.reloadGen != cmdGen {
@@ -111,8 +287,17 @@ func (cs *cmdReloader) Reload() {
 		}
 		cs.waitErr = err
 		cs.waitFinished = true
+		cs.lastExit = cw.cmd.ProcessState
 		cs.cond.Broadcast()
-	}(cs.cmd.cmd, cs.reloadGen)
+		cs.emitExit(cw, cmdGen)
+		// The output is taken before the banner is added to it.
+		output := cw.output.Bytes()
+		cs.finished(cw)
+		cs.notify(runChange{state: runExited, gen: cmdGen, err: err, output: output})
+		if !cw.stopped && !cs.preventReloads && cs.restart.restarts(err) {
+			cs.scheduleRestart(err)
+		}
+	}(cs.cmd, cs.reloadGen)
 
 	if cs.waitForCommand {
 		// Unlock is here to allow the code that furnishes the error returned from the
@@ -121,9 +306,6 @@ func (cs *cmdReloader) Reload() {
 		cs.cond.L.Unlock()
 		cs.wait()
 		cs.cond.L.Lock()
-		if err != nil {
-			log.Printf("command finished with error: %s", err)
-		}
 	}
 	return
 }
@@ -133,13 +315,212 @@ func (cs *cmdReloader) Reload() {
 // Wait of process created by the cmdReloader has finished. This will never
 // return if the process is hung.
 func (cs *cmdReloader) Terminate() {
+	cs.TerminateWith(cs.stopSignal)
+}
+
+// TerminateWith is like Terminate, but shuts down the command process with
+// sig instead of the cmdReloader's stop signal.
+func (cs *cmdReloader) TerminateWith(sig syscall.Signal) {
 	cs.cond.L.Lock()
 	cs.preventReloads = true
+	cs.cancelRestart()
+	started := cs.cmd != nil
 	cs.cond.L.Unlock()
-	cs.terminate()
+	if started {
+		cs.terminate(sig)
+	}
 }
 
-func isTerminated(err error) bool {
+// emitExit records that the run of the command in cw exited. It must be
+// called with cs.cond.L held.
+func (cs *cmdReloader) emitExit(cw *cmdWrapper, gen int) {
+	if cs.lifecycle == nil {
+		return
+	}
+	state := cw.cmd.ProcessState
+	rec := lifecycleRecord{
+		Type:       recRunExit,
+		Rule:       cs.name,
+		Pid:        state.Pid(),
+		Generation: gen,
+		Duration:   time.Since(cs.started).Seconds(),
+	}
+	status := state.Sys().(syscall.WaitStatus)
+	if status.Signaled() {
+		rec.Signal = signalName(status.Signal())
+	} else {
+		code := status.ExitStatus()
+		rec.ExitCode = &code
+	}
+	cs.lifecycle.emit(rec)
+}
+
+// finished writes a banner describing how the run of the command in cw
+// ended to its stdout, and counts the run if it exited on its own. It must
+// be called with cs.cond.L held.
+func (cs *cmdReloader) finished(cw *cmdWrapper) {
+	state := cw.cmd.ProcessState
+	wall := time.Since(cs.started)
+	if !cw.stopped {
+		cs.stats.add(state.Success(), wall)
+	}
+	banner := runBanner(state, cw.stopped, wall, cs.color)
+	if cs.summary {
+		banner += " [" + cs.stats.String() + "]"
+	}
+	fmt.Fprintln(cw.stdout, banner)
+}
+
+// Status returns the current state of the command.
+func (cs *cmdReloader) Status() cmdStatus {
+	cs.cond.L.Lock()
+	defer cs.cond.L.Unlock()
+	st := cmdStatus{Generation: cs.reloadGen, runStats: cs.stats}
+	if cs.cmd != nil && !cs.waitFinished {
+		st.Pid = cs.cmd.cmd.Process.Pid
+		st.Running = true
+	}
+	if !cs.started.IsZero() {
+		started := cs.started
+		st.Started = &started
+	}
+	if cs.lastExit != nil {
+		code := cs.lastExit.ExitCode()
+		st.LastExit = cs.lastExit.String()
+		st.LastExitCode = &code
+	}
+	if st.Running && cs.readyGen == cs.reloadGen {
+		st.Ready = true
+		st.ReadyAfter = cs.readyAt.Sub(cs.started).Seconds()
+	}
+	return st
+}
+
+// awaitReady probes the run of generation gen until it is ready, exits, or
+// is replaced by another run.
+func (cs *cmdReloader) awaitReady(gen int) {
+	for {
+		if cs.ready.probe() {
+			cs.markReady(gen)
+			return
+		}
+		time.Sleep(readyInterval)
+		cs.cond.L.Lock()
+		current := cs.reloadGen == gen && !cs.waitFinished
+		cs.cond.L.Unlock()
+		if !current {
+			return
+		}
+	}
+}
+
+// markReady records that the run of generation gen is ready if it is
+// still running.
+func (cs *cmdReloader) markReady(gen int) {
+	cs.cond.L.Lock()
+	defer cs.cond.L.Unlock()
+	if cs.reloadGen != gen || cs.waitFinished || cs.readyGen == gen {
+		return
+	}
+	cs.setReady(gen)
+	after := cs.readyAt.Sub(cs.started)
+	cs.log.Info("command is ready", "generation", gen, "after", after.Round(time.Millisecond))
+	cs.lifecycle.emit(lifecycleRecord{
+		Type:       recRunReady,
+		Rule:       cs.name,
+		Pid:        cs.cmd.cmd.Process.Pid,
+		Generation: gen,
+		Duration:   after.Seconds(),
+	})
+}
+
+// setReady records that the run of generation gen is ready and tells the
+// listeners. It must be called with cs.cond.L held.
+func (cs *cmdReloader) setReady(gen int) {
+	cs.readyGen = gen
+	cs.readyAt = time.Now()
+	cs.notify(runChange{state: runReady, gen: gen})
+}
+
+// onChange adds f to the funcs told as runs of the command reach each
+// runState. It must be called before the command is first run. f is called
+// with cs.cond.L held, so it must not call the cmdReloader's methods.
+func (cs *cmdReloader) onChange(f func(runChange)) {
+	cs.listeners = append(cs.listeners, f)
+}
+
+// notify tells the listeners about c. It must be called with cs.cond.L
+// held.
+func (cs *cmdReloader) notify(c runChange) {
+	for _, f := range cs.listeners {
+		f(c)
+	}
+}
+
+// Stop terminates the running command, if there is one, and cancels its
+// pending restart. Unlike Terminate, it leaves later calls to Reload free to
+// run the command again.
+func (cs *cmdReloader) Stop() {
+	cs.cond.L.Lock()
+	cs.cancelRestart()
+	running := cs.cmd != nil && !cs.waitFinished
+	cs.cond.L.Unlock()
+	if running {
+		cs.terminate(cs.stopSignal)
+	}
+}
+
+// scheduleRestart starts the command again after the next backoff time. It
+// must be called with cs.cond.L held.
+func (cs *cmdReloader) scheduleRestart(err error) {
+	d := cs.backoff.next().Round(time.Millisecond)
+	if err != nil {
+		cs.log.Info("command exited with error, restarting it", "err", err, "in", d)
+	} else {
+		cs.log.Info("command exited, restarting it", "in", d)
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		cs.cond.L.Lock()
+		defer cs.cond.L.Unlock()
+		// The timer is no longer current if the command was reloaded
+		// or terminated since it was set.
+		if cs.restartTimer != t || cs.preventReloads {
+			return
+		}
+		cs.restartTimer = nil
+		cs.start()
+	})
+	cs.restartTimer = t
+}
+
+// cancelRestart stops the pending restart, if any. It must be called with
+// cs.cond.L held.
+func (cs *cmdReloader) cancelRestart() {
+	if cs.restartTimer != nil {
+		cs.restartTimer.Stop()
+		cs.restartTimer = nil
+	}
+}
+
+// Signal sends sig to the process group of the running command. It returns
+// false if no command is running.
+func (cs *cmdReloader) Signal(sig syscall.Signal) bool {
+	cs.cond.L.Lock()
+	defer cs.cond.L.Unlock()
+	if cs.cmd == nil || cs.waitFinished {
+		return false
+	}
+	err := cs.cmd.Signal(sig)
+	if err != nil && err != syscall.ESRCH {
+		cs.log.Warn("unable to signal command", "signal", sig, "pid", cs.cmd.cmd.Process.Pid, "err", err)
+	}
+	return err == nil
+}
+
+// isTerminated returns true if err is from a command that was killed by one
+// of the signals sent to it.
+func isTerminated(err error, sent []syscall.Signal) bool {
 	exitErr, ok := err.(*exec.ExitError)
 	if !ok {
 		return false
@@ -149,29 +530,76 @@ func isTerminated(err error) bool {
 	if !status.Signaled() {
 		return false
 	}
-	return status.Signal() == syscall.SIGTERM
+	for _, sig := range sent {
+		if status.Signal() == sig {
+			return true
+		}
+	}
+	return false
 }
 
-// terminate must be called without cs.cond.L being held.
-func (cs *cmdReloader) terminate() {
+// terminate sends sig to the running command and waits for it to exit. It
+// must be called without cs.cond.L being held.
+func (cs *cmdReloader) terminate(sig syscall.Signal) {
 	pid := cs.cmd.cmd.Process.Pid
-	msg := "terminating current command"
-	if *verbose {
-		msg += fmt.Sprintf(" pid %d", pid)
-	}
-	log.Println(msg)
+	cs.log.Info("terminating current command", "pid", pid)
 
 	cs.cond.L.Lock()
 	defer cs.cond.L.Unlock()
-	err := cs.cmd.Terminate()
-	if *verbose && err != nil && err != syscall.ESRCH {
-		log.Printf("error when attempting to terminate pid %d: %s", pid, err)
+	cw := cs.cmd
+	err := cw.Terminate(sig)
+	cs.lifecycle.emit(lifecycleRecord{
+		Type:       recRunTerm,
+		Rule:       cs.name,
+		Pid:        pid,
+		Generation: cs.reloadGen,
+		Signal:     signalName(sig),
+	})
+	cs.notify(runChange{state: runStopping, gen: cs.reloadGen})
+	if err != nil && err != syscall.ESRCH {
+		cs.log.Debug("error when attempting to terminate command", "pid", pid, "err", err)
 	}
 	cs.cond.L.Unlock()
+	var killTimer *time.Timer
+	deadline := time.Now().Add(cs.killAfter)
+	if cs.killAfter > 0 {
+		killTimer = time.AfterFunc(cs.killAfter, func() {
+			cs.kill(cw, pid)
+		})
+	}
 	err = cs.wait()
+	if killTimer != nil && killTimer.Stop() {
+		// The shell has exited, but processes it started may
+		// still be running in its process group.
+		cs.killLeftovers(cw, pid, deadline)
+	}
 	cs.cond.L.Lock()
-	if *verbose && err != nil && err != syscall.ESRCH && !isTerminated(err) {
-		log.Printf("error in process termination of pid %d: %s", pid, err)
+	if err != nil && err != syscall.ESRCH && !isTerminated(err, cw.sent) {
+		cs.log.Debug("error in command termination", "pid", pid, "err", err)
+	}
+}
+
+// kill sends SIGKILL to the process group of cw after it has failed to exit
+// in time.
+func (cs *cmdReloader) kill(cw *cmdWrapper, pid int) {
+	cs.log.Warn("command did not exit after being terminated, sending SIGKILL", "pid", pid, "after", cs.killAfter)
+	cs.cond.L.Lock()
+	err := cw.Kill()
+	cs.cond.L.Unlock()
+	if err != nil && err != syscall.ESRCH {
+		cs.log.Warn("unable to kill command", "pid", pid, "err", err)
+	}
+}
+
+// killLeftovers waits until the deadline for the processes remaining in
+// cw's process group to exit, and kills them if they don't.
+func (cs *cmdReloader) killLeftovers(cw *cmdWrapper, pid int, deadline time.Time) {
+	for cw.alive() {
+		if time.Now().After(deadline) {
+			cs.kill(cw, pid)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
 	}
 }
 
@@ -186,3 +614,80 @@ func (cs *cmdReloader) wait() error {
 	cs.cond.L.Unlock()
 	return err
 }
+
+// shellQuote quotes s so that the shell treats it as a single word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeChangedFile writes the paths, one per line, to the file at path.
+func writeChangedFile(path string, changed []string) error {
+	var buf bytes.Buffer
+	for _, p := range changed {
+		buf.WriteString(p)
+		buf.WriteByte('\n')
+	}
+	return os.WriteFile(path, buf.Bytes(), 0666)
+}
+
+// maxOutputKept is how much of the end of each run's output is kept for
+// the listeners told it exited.
+const maxOutputKept = 64 * 1024
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (tb *tailBuffer) Write(p []byte) (int, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.buf = append(tb.buf, p...)
+	// The buffer is allowed to grow to twice max so that it isn't
+	// copied on every write.
+	if len(tb.buf) > 2*tb.max {
+		tb.buf = append([]byte(nil), tb.buf[len(tb.buf)-tb.max:]...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the last max bytes written.
+func (tb *tailBuffer) Bytes() []byte {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	b := tb.buf
+	if len(b) > tb.max {
+		b = b[len(b)-tb.max:]
+	}
+	return append([]byte(nil), b...)
+}
+
+// prefixWriter writes prefix at the start of every line written through
+// it to w.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	// midLine is true if the last write did not end with a newline.
+	midLine bool
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !pw.midLine {
+			buf.WriteString(pw.prefix)
+		}
+		buf.Write(line)
+		pw.midLine = line[len(line)-1] != '\n'
+	}
+	_, err := pw.w.Write(buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
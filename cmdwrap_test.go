@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestReloader(command string) *cmdReloader {
+	return &cmdReloader{
+		log:        slog.Default(),
+		cond:       &sync.Cond{L: new(sync.Mutex)},
+		command:    command,
+		shell:      "sh",
+		stopSignal: syscall.SIGTERM,
+	}
+}
+
+func TestKillAfter(t *testing.T) {
+	cs := newTestReloader("trap '' TERM; while true; do sleep 0.1; done")
+	cs.killAfter = 200 * time.Millisecond
+	cs.Reload(nil)
+	// Give the shell a chance to install its trap.
+	time.Sleep(200 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		cs.Terminate()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Terminate did not return after the command ignored SIGTERM")
+	}
+	if !isTerminated(cs.waitErr, []syscall.Signal{syscall.SIGKILL}) {
+		t.Errorf("want the command to have been killed, got %v", cs.waitErr)
+	}
+}
+
+func TestChangedPaths(t *testing.T) {
+	dir := t.TempDir()
+	envOut := filepath.Join(dir, "env")
+	substOut := filepath.Join(dir, "subst")
+	changedFile := filepath.Join(dir, "changed")
+	cs := newTestReloader(`printf '%s' "$JUSTRUN_CHANGED" > ` + envOut + ` && for p in {}; do echo "$p"; done > ` + substOut)
+	cs.waitForCommand = true
+	cs.substitute = true
+	cs.changedFile = changedFile
+	cs.Reload([]string{"/a/b.go", "/a/it's here.go"})
+
+	want := "/a/b.go\n/a/it's here.go"
+	files := map[string]string{
+		envOut:      want,
+		substOut:    want + "\n",
+		changedFile: want + "\n",
+	}
+	for path, want := range files {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("unable to read %s: %s", path, err)
+			continue
+		}
+		if string(b) != want {
+			t.Errorf("%s: want %q, got %q", filepath.Base(path), want, string(b))
+		}
+	}
+}
+
+func TestRestart(t *testing.T) {
+	tests := []struct {
+		policy  restartPolicy
+		command string
+		want    bool
+	}{
+		{restartOnFailure, "exit 3", true},
+		{restartOnFailure, "exit 0", false},
+		{restartAlways, "exit 0", true},
+		{restartNever, "exit 3", false},
+	}
+	for _, tc := range tests {
+		runs := filepath.Join(t.TempDir(), "runs")
+		cs := newTestReloader("echo run >> " + runs + "; " + tc.command)
+		cs.restart = tc.policy
+		cs.backoff = backoff{min: 10 * time.Millisecond, max: 20 * time.Millisecond}
+		cs.Reload(nil)
+		time.Sleep(500 * time.Millisecond)
+		cs.Terminate()
+		b, err := os.ReadFile(runs)
+		if err != nil {
+			t.Fatalf("unable to read %s: %s", runs, err)
+		}
+		n := strings.Count(string(b), "\n")
+		if tc.want && n < 3 {
+			t.Errorf("%s with %#v: want the command restarted, got %d runs", tc.policy.String(), tc.command, n)
+		}
+		if !tc.want && n != 1 {
+			t.Errorf("%s with %#v: want 1 run, got %d", tc.policy.String(), tc.command, n)
+		}
+	}
+}
+
+func TestNoRestartAfterReload(t *testing.T) {
+	cs := newTestReloader("sleep 10")
+	cs.restart = restartAlways
+	cs.backoff = backoff{min: 10 * time.Millisecond, max: 20 * time.Millisecond}
+	cs.Reload(nil)
+	cs.Reload(nil)
+	cs.cond.L.Lock()
+	gen := cs.reloadGen
+	cs.cond.L.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	cs.cond.L.Lock()
+	restarted := cs.reloadGen != gen || cs.restartTimer != nil
+	cs.cond.L.Unlock()
+	cs.Terminate()
+	if restarted {
+		t.Errorf("command stopped by a reload was restarted")
+	}
+}
+
+func TestLifecycleRecords(t *testing.T) {
+	var buf bytes.Buffer
+	cs := newTestReloader("exit 4")
+	cs.lifecycle = &lifecycleLog{enc: json.NewEncoder(&buf)}
+	cs.waitForCommand = true
+	cs.Reload([]string{"/a/b.go"})
+
+	var recs []lifecycleRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec lifecycleRecord
+		err := dec.Decode(&rec)
+		if err != nil {
+			t.Fatalf("unable to parse record: %s", err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("want 2 records, got %d: %+v", len(recs), recs)
+	}
+	start, exit := recs[0], recs[1]
+	if start.Type != recRunStart || start.Pid == 0 || start.Generation != 1 || len(start.Changed) != 1 {
+		t.Errorf("bad run_started record: %+v", start)
+	}
+	if exit.Type != recRunExit || exit.Pid != start.Pid || exit.ExitCode == nil || *exit.ExitCode != 4 {
+		t.Errorf("bad run_exited record: %+v", exit)
+	}
+}
+
+func TestReadyCheck(t *testing.T) {
+	cs := newTestReloader("echo starting; sleep 0.2; echo listening; sleep 10")
+	cs.ready = &readyCheck{pattern: regexp.MustCompile("^listening$")}
+	changes := make(chan runChange, 10)
+	cs.onChange(func(c runChange) { changes <- c })
+	cs.Reload(nil)
+	defer cs.Terminate()
+
+	if c := <-changes; c.state != runStarted || c.gen != 1 {
+		t.Fatalf("want generation 1 started, got %+v", c)
+	}
+	if cs.Status().Ready {
+		t.Errorf("command ready before it printed the pattern")
+	}
+	select {
+	case c := <-changes:
+		if c.state != runReady || c.gen != 1 {
+			t.Fatalf("want generation 1 ready, got %+v", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("command never became ready")
+	}
+	st := cs.Status()
+	if !st.Ready || st.ReadyAfter < 0.2 {
+		t.Errorf("want status ready after at least 0.2s, got %+v", st)
+	}
+
+	cs.Reload(nil)
+	want := []runState{runStopping, runExited, runStarted}
+	for _, s := range want {
+		if c := <-changes; c.state != s {
+			t.Fatalf("want %s, got %+v", s, c)
+		}
+	}
+	if cs.Status().Ready {
+		t.Errorf("new run ready before it printed the pattern")
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// configFileName is the name of the config file justrun looks for in the
+// current working directory and its parents.
+const configFileName = "justrun.json"
+
+// config is the contents of a config file. Its fields mirror the
+// commandline flags of the same names, and the flags override them. Paths
+// in it are relative to the directory the config file is in.
+type config struct {
+	Command   string   `json:"command"`
+	Shell     string   `json:"shell"`
+	Delay     duration `json:"delay"`
+	Debounce  duration `json:"debounce"`
+	MaxWait   duration `json:"max_wait"`
+	Wait      bool     `json:"wait"`
+	KillAfter duration `json:"kill_after"`
+	Recursive bool     `json:"recursive"`
+	Gitignore bool     `json:"gitignore"`
+
+	Poll         duration `json:"poll"`
+	PollFallback duration `json:"poll_fallback"`
+	EventBuffer  uint     `json:"event_buffer"`
+	Hash         bool     `json:"hash"`
+
+	Restart restartPolicy `json:"restart"`
+	Ready   string        `json:"ready"`
+	Listen  string        `json:"listen"`
+
+	Proxy      string `json:"proxy"`
+	Upstream   string `json:"upstream"`
+	LiveReload string `json:"livereload"`
+
+	Events     string `json:"events"`
+	EventsFile string `json:"events_file"`
+	LogFormat  string `json:"log_format"`
+	Summary    bool   `json:"summary"`
+
+	Signal         signalValue `json:"signal"`
+	SignalOn       []string    `json:"signal_on"`
+	ForwardSignals bool        `json:"forward_signals"`
+
+	ChangedFile string   `json:"changed_file"`
+	Subst       bool     `json:"subst"`
+	Paths       []string `json:"paths"`
+	Ignore      []string `json:"ignore"`
+	Exclude     []string `json:"exclude"`
+	Ops         []string `json:"ops"`
+
+	// Rules, if given, replace the command and paths above with a set
+	// of named commands, each run when its own paths change.
+	Rules []*ruleConfig `json:"rules"`
+
+	// dir is the directory the config file was loaded from.
+	dir string
+}
+
+// ruleConfig is a named command and the paths that cause it to be run. The
+// settings it leaves out default to the ones of the config file or
+// commandline.
+type ruleConfig struct {
+	Name     string      `json:"name"`
+	Command  string      `json:"command"`
+	Shell    string      `json:"shell"`
+	Delay    duration    `json:"delay"`
+	Debounce duration    `json:"debounce"`
+	MaxWait  duration    `json:"max_wait"`
+	Signal   signalValue `json:"signal"`
+	SignalOn []string    `json:"signal_on"`
+
+	// Wait, Recursive, KillAfter, Subst and Restart are pointers so that
+	// a rule can turn off the top-level ones.
+	Wait      *bool          `json:"wait"`
+	Recursive *bool          `json:"recursive"`
+	KillAfter *duration      `json:"kill_after"`
+	Subst     *bool          `json:"subst"`
+	Restart   *restartPolicy `json:"restart"`
+
+	Ready string `json:"ready"`
+
+	Proxy    string `json:"proxy"`
+	Upstream string `json:"upstream"`
+
+	ChangedFile string `json:"changed_file"`
+
+	Paths   []string `json:"paths"`
+	Ignore  []string `json:"ignore"`
+	Exclude []string `json:"exclude"`
+	Ops     []string `json:"ops"`
+}
+
+// duration is a time.Duration that is written in config files as a string
+// like "750ms".
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return errors.New("durations must be strings like \"750ms\"")
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// readConfig loads the config file at path or, if path is empty, the one
+// found by findConfig. It returns nil if path is empty and no config file
+// was found.
+func readConfig(path string) (*config, error) {
+	if path == "" {
+		var err error
+		path, err = findConfig()
+		if err != nil || path == "" {
+			return nil, err
+		}
+	}
+	return loadConfig(path)
+}
+
+// findConfig looks for a config file in the current working directory and
+// then in each of its parents. It returns the empty string if there is
+// none.
+func findConfig() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", errors.New("unable to get current working dir while looking for a config file")
+	}
+	for {
+		path := filepath.Join(dir, configFileName)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+func loadConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %s", err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, errors.New("unable to get current working dir while reading the config file")
+	}
+	cfg := &config{dir: filepath.Dir(path)}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config file '%s': %s", path, err)
+	}
+	cfg.Paths = cfg.resolve(cfg.Paths)
+	cfg.Ignore = cfg.resolve(cfg.Ignore)
+	names := make(map[string]bool)
+	for i, rc := range cfg.Rules {
+		switch {
+		case rc.Name == "":
+			return nil, fmt.Errorf("rule %d in config file '%s' has no name", i, path)
+		case names[rc.Name]:
+			return nil, fmt.Errorf("more than one rule in config file '%s' is named '%s'", path, rc.Name)
+		case rc.Command == "":
+			return nil, fmt.Errorf("rule '%s' in config file '%s' has no command", rc.Name, path)
+		case len(rc.Paths) == 0:
+			return nil, fmt.Errorf("rule '%s' in config file '%s' has no paths", rc.Name, path)
+		}
+		names[rc.Name] = true
+		rc.Paths = cfg.resolve(rc.Paths)
+		rc.Ignore = cfg.resolve(rc.Ignore)
+		if rc.ChangedFile != "" {
+			rc.ChangedFile = cfg.resolve([]string{rc.ChangedFile})[0]
+		}
+	}
+	return cfg, nil
+}
+
+// resolve makes the relative paths given relative to the config file's
+// directory instead of the current working directory.
+func (cfg *config) resolve(paths []string) []string {
+	resolved := make([]string, len(paths))
+	for i, p := range paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(cfg.dir, p)
+		}
+		resolved[i] = p
+	}
+	return resolved
+}
+
+// applyToFlags sets the flags that are not in set, the names of the ones
+// given on the commandline, to the values in the config file.
+func (cfg *config) applyToFlags(set map[string]bool) {
+	if !set["c"] && cfg.Command != "" {
+		*command = cfg.Command
+	}
+	if !set["s"] && cfg.Shell != "" {
+		*shell = cfg.Shell
+	}
+	if !set["delay"] && cfg.Delay.Duration != 0 {
+		*delayDur = cfg.Delay.Duration
+	}
+	if !set["debounce"] && cfg.Debounce.Duration != 0 {
+		*debounceDur = cfg.Debounce.Duration
+	}
+	if !set["max-wait"] && cfg.MaxWait.Duration != 0 {
+		*maxWaitDur = cfg.MaxWait.Duration
+	}
+	if !set["w"] {
+		*waitForCommand = cfg.Wait
+	}
+	if !set["kill-after"] && cfg.KillAfter.Duration != 0 {
+		*killAfterDur = cfg.KillAfter.Duration
+	}
+	if !set["restart"] && cfg.Restart != restartNever {
+		restartFlag = cfg.Restart
+	}
+	if !set["ready"] && cfg.Ready != "" {
+		*readyFlag = cfg.Ready
+	}
+	if !set["proxy"] && cfg.Proxy != "" {
+		*proxyFlag = cfg.Proxy
+	}
+	if !set["upstream"] && cfg.Upstream != "" {
+		*upstreamFlag = cfg.Upstream
+	}
+	if !set["livereload"] && cfg.LiveReload != "" {
+		*liveReloadFlag = cfg.LiveReload
+	}
+	if !set["signal"] && cfg.Signal.Signal != 0 {
+		stopSignal = cfg.Signal
+	}
+	if !set["ops"] && len(cfg.Ops) != 0 {
+		opsFlag = cfg.Ops
+	}
+	if !set["signal-on"] && len(cfg.SignalOn) != 0 {
+		signalOnFlag = cfg.SignalOn
+	}
+	if !set["forward-signals"] {
+		*forwardSignals = cfg.ForwardSignals
+	}
+	if !set["changed-file"] && cfg.ChangedFile != "" {
+		*changedFileFlag = cfg.resolve([]string{cfg.ChangedFile})[0]
+	}
+	if !set["subst"] {
+		*substFlag = cfg.Subst
+	}
+	if !set["poll"] && cfg.Poll.Duration != 0 {
+		*pollDur = cfg.Poll.Duration
+	}
+	if !set["poll-fallback"] && cfg.PollFallback.Duration != 0 {
+		*pollFallbackDur = cfg.PollFallback.Duration
+	}
+	if !set["event-buffer"] && cfg.EventBuffer != 0 {
+		*eventBuffer = cfg.EventBuffer
+	}
+	if !set["hash"] {
+		*hashFlag = cfg.Hash
+	}
+	if !set["r"] {
+		*recursive = cfg.Recursive
+	}
+	if !set["gitignore"] {
+		*gitignore = cfg.Gitignore
+	}
+	if !set["i"] {
+		ignoreFlag = cfg.Ignore
+	}
+	if !set["summary"] {
+		*summaryFlag = cfg.Summary
+	}
+	if !set["log-format"] && cfg.LogFormat != "" {
+		*logFormat = cfg.LogFormat
+	}
+	if !set["events"] && cfg.Events != "" {
+		*eventsFlag = cfg.Events
+	}
+	if !set["events-file"] && cfg.EventsFile != "" {
+		*eventsFileFlag = cfg.EventsFile
+		if !strings.HasPrefix(cfg.EventsFile, "fd:") {
+			*eventsFileFlag = cfg.resolve([]string{cfg.EventsFile})[0]
+		}
+	}
+	if !set["listen"] && cfg.Listen != "" {
+		*listenFlag = cfg.listenAddr()
+	}
+}
+
+// listenAddr returns the config file's control API address with a relative
+// unix socket path made relative to the config file's directory.
+func (cfg *config) listenAddr() string {
+	network, address, err := parseListenAddr(cfg.Listen)
+	if err != nil || network != "unix" {
+		return cfg.Listen
+	}
+	return "unix:" + cfg.resolve([]string{address})[0]
+}
+
+// newRule creates the rule described by rc, one of the config file's rules,
+// that logs to log and writes records of its runs to lifecycle. The rule
+// ignores the top-level ignored paths and exclude patterns along with its
+// own.
+func (cfg *config) newRule(rc *ruleConfig, log *slog.Logger, lifecycle *lifecycleLog) (*rule, error) {
+	wc := watchConfig{
+		inputPaths:     rc.Paths,
+		ignoredPaths:   append(rc.Ignore[:len(rc.Ignore):len(rc.Ignore)], ignoreFlag...),
+		ignorePatterns: append(cfg.Exclude[:len(cfg.Exclude):len(cfg.Exclude)], rc.Exclude...),
+		patternBase:    cfg.dir,
+		gitignore:      *gitignore,
+		recursive:      *recursive,
+		ops:            rc.Ops,
+	}
+	if rc.Recursive != nil {
+		wc.recursive = *rc.Recursive
+	}
+	if len(wc.ops) == 0 {
+		wc.ops = opsFlag
+	}
+	return newRule(rc, wc, log, lifecycle)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFileName)
+	contents := `{
+	"command": "go build && ./server",
+	"delay": "2s",
+	"wait": true,
+	"paths": [".", "/abs/templates"],
+	"ignore": ["server"],
+	"exclude": ["*.swp"]
+}`
+	err := os.WriteFile(path, []byte(contents), 0666)
+	if err != nil {
+		t.Fatalf("unable to write config file: %s", err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unable to load config file: %s", err)
+	}
+	if cfg.Command != "go build && ./server" {
+		t.Errorf("Command: want %#v, got %#v", "go build && ./server", cfg.Command)
+	}
+	if cfg.Delay.Duration != 2*time.Second {
+		t.Errorf("Delay: want %s, got %s", 2*time.Second, cfg.Delay.Duration)
+	}
+	if !cfg.Wait {
+		t.Errorf("Wait: want true, got false")
+	}
+	wantPaths := []string{dir, "/abs/templates"}
+	if !reflect.DeepEqual(cfg.Paths, wantPaths) {
+		t.Errorf("Paths: want %#v, got %#v", wantPaths, cfg.Paths)
+	}
+	wantIgnore := []string{filepath.Join(dir, "server")}
+	if !reflect.DeepEqual(cfg.Ignore, wantIgnore) {
+		t.Errorf("Ignore: want %#v, got %#v", wantIgnore, cfg.Ignore)
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	err := os.WriteFile(path, []byte(`{"comand": "make"}`), 0666)
+	if err != nil {
+		t.Fatalf("unable to write config file: %s", err)
+	}
+	_, err = loadConfig(path)
+	if err == nil {
+		t.Errorf("expected an error for a misspelled field")
+	}
+}
+
+func TestApplyToFlags(t *testing.T) {
+	keepFlag(t, command)
+	keepFlag(t, delayDur)
+	keepFlag(t, waitForCommand)
+	keepFlag(t, recursive)
+	keepFlag(t, &ignoreFlag)
+	// applyToFlags sets these from the config file even when it leaves
+	// them out.
+	keepFlag(t, forwardSignals)
+	keepFlag(t, substFlag)
+	keepFlag(t, hashFlag)
+	keepFlag(t, gitignore)
+	keepFlag(t, summaryFlag)
+
+	cfg := &config{
+		Command:   "make",
+		Delay:     duration{2 * time.Second},
+		Wait:      true,
+		Recursive: true,
+		Ignore:    []string{"/a/bin"},
+	}
+	tests := []struct {
+		args    []string
+		command string
+		delay   time.Duration
+		wait    bool
+		rec     bool
+		ignore  []string
+	}{
+		{nil, "make", 2 * time.Second, true, true, []string{"/a/bin"}},
+		{[]string{"-c", "go test", "-delay", "1s"}, "go test", time.Second, true, true, []string{"/a/bin"}},
+		{[]string{"-w=false", "-r=false", "-i", "/b/out"}, "make", 2 * time.Second, false, false, []string{"/b/out"}},
+	}
+	for _, tc := range tests {
+		fs := flag.NewFlagSet("justrun", flag.ContinueOnError)
+		fs.StringVar(command, "c", "", "")
+		fs.DurationVar(delayDur, "delay", 750*time.Millisecond, "")
+		fs.BoolVar(waitForCommand, "w", false, "")
+		fs.BoolVar(recursive, "r", false, "")
+		ignoreFlag = nil
+		fs.Var(&ignoreFlag, "i", "")
+		err := fs.Parse(tc.args)
+		if err != nil {
+			t.Fatalf("%v: unable to parse flags: %s", tc.args, err)
+		}
+		cfg.applyToFlags(flagsSet(fs))
+		if *command != tc.command {
+			t.Errorf("%v: command: want %#v, got %#v", tc.args, tc.command, *command)
+		}
+		if *delayDur != tc.delay {
+			t.Errorf("%v: delay: want %s, got %s", tc.args, tc.delay, *delayDur)
+		}
+		if *waitForCommand != tc.wait {
+			t.Errorf("%v: wait: want %t, got %t", tc.args, tc.wait, *waitForCommand)
+		}
+		if *recursive != tc.rec {
+			t.Errorf("%v: recursive: want %t, got %t", tc.args, tc.rec, *recursive)
+		}
+		if !reflect.DeepEqual([]string(ignoreFlag), tc.ignore) {
+			t.Errorf("%v: ignore: want %#v, got %#v", tc.args, tc.ignore, ignoreFlag)
+		}
+	}
+}
+
+// keepFlag restores the flag value p points to once the test is done.
+func keepFlag[T any](t *testing.T, p *T) {
+	old := *p
+	t.Cleanup(func() { *p = old })
+}
+
+func TestConfigNewRule(t *testing.T) {
+	keepFlag(t, recursive)
+	keepFlag(t, &ignoreFlag)
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFileName)
+	contents := `{
+	"exclude": ["*.swp"],
+	"rules": [
+		{"name": "web", "command": "make", "paths": ["web"], "ignore": ["web/dist"], "exclude": ["*.map"]},
+		{"name": "docs", "command": "make docs", "paths": ["docs"], "recursive": false}
+	]
+}`
+	err := os.WriteFile(path, []byte(contents), 0666)
+	if err != nil {
+		t.Fatalf("unable to write config file: %s", err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unable to load config file: %s", err)
+	}
+	// Like -r -i /a/bin.
+	*recursive = true
+	ignoreFlag = []string{"/a/bin"}
+
+	web, err := cfg.newRule(cfg.Rules[0], slog.Default(), nil)
+	if err != nil {
+		t.Fatalf("unable to create web rule: %s", err)
+	}
+	if !web.wc.recursive {
+		t.Errorf("web: want the top-level recursive")
+	}
+	wantIgnore := []string{filepath.Join(dir, "web/dist"), "/a/bin"}
+	if !reflect.DeepEqual(web.wc.ignoredPaths, wantIgnore) {
+		t.Errorf("web: ignored paths: want %#v, got %#v", wantIgnore, web.wc.ignoredPaths)
+	}
+	wantExclude := []string{"*.swp", "*.map"}
+	if !reflect.DeepEqual(web.wc.ignorePatterns, wantExclude) {
+		t.Errorf("web: exclude: want %#v, got %#v", wantExclude, web.wc.ignorePatterns)
+	}
+
+	docs, err := cfg.newRule(cfg.Rules[1], slog.Default(), nil)
+	if err != nil {
+		t.Fatalf("unable to create docs rule: %s", err)
+	}
+	if docs.wc.recursive {
+		t.Errorf("docs: a rule's recursive false didn't turn off the top-level one")
+	}
+}
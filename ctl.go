@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const ctlUsage = `usage: justrun ctl [-listen ADDR] [-rule NAME] COMMAND [PATH]*
+
+commands:
+  status          print the state of the rules as JSON
+  rerun           rerun the commands
+  pause           stop rerunning the commands as files change
+  resume          start rerunning the commands as files change again
+  events          print the fs events of the rules as they happen, as JSON
+  add PATH...     watch more paths
+  remove PATH...  stop watching paths
+`
+
+// ctlMain runs the "justrun ctl" subcommand, which talks to the control
+// API of a running justrun.
+func ctlMain(args []string) {
+	fset := flag.NewFlagSet("ctl", flag.ExitOnError)
+	listen := fset.String("listen", "", "the address of the control API, defaulting to the listen setting of the config file")
+	ruleName := fset.String("rule", "", "the name of the rule to control, or all of them if empty")
+	fset.Usage = func() {
+		fmt.Fprint(os.Stderr, ctlUsage)
+		fset.PrintDefaults()
+		os.Exit(1)
+	}
+	fset.Parse(args)
+	if fset.NArg() == 0 {
+		fset.Usage()
+	}
+	addr := *listen
+	if addr == "" {
+		cfg, err := readConfig("")
+		if err != nil {
+			ctlFatal("%s", err)
+		}
+		if cfg == nil || cfg.Listen == "" {
+			ctlFatal("no -listen address given and no listen setting found in a config file")
+		}
+		addr = cfg.listenAddr()
+	}
+	c, err := newCtlClient(addr)
+	if err != nil {
+		ctlFatal("%s", err)
+	}
+
+	params := url.Values{}
+	if *ruleName != "" {
+		params.Set("rule", *ruleName)
+	}
+	cmd, rest := fset.Arg(0), fset.Args()[1:]
+	switch cmd {
+	case "status":
+		err = c.do("GET", "/status", params, os.Stdout)
+	case "rerun", "pause", "resume":
+		err = c.do("POST", "/"+cmd, params, nil)
+	case "events":
+		err = c.do("GET", "/events", params, os.Stdout)
+		if err == io.ErrUnexpectedEOF {
+			// justrun exited.
+			err = nil
+		}
+	case "add", "remove":
+		if len(rest) == 0 {
+			ctlFatal("no paths given to %s", cmd)
+		}
+		for _, p := range rest {
+			// Relative paths are made absolute here because justrun
+			// may be running in another directory.
+			if !hasMeta(p) {
+				abs, err := filepath.Abs(p)
+				if err != nil {
+					ctlFatal("unable to get current working dir while working with paths")
+				}
+				p = abs
+			}
+			params.Add("path", p)
+		}
+		method := "POST"
+		if cmd == "remove" {
+			method = "DELETE"
+		}
+		err = c.do(method, "/paths", params, nil)
+	default:
+		ctlFatal("unknown command '%s'", cmd)
+	}
+	if err != nil {
+		ctlFatal("%s", err)
+	}
+}
+
+func ctlFatal(format string, obj ...interface{}) {
+	fmt.Fprintf(os.Stderr, "justrun ctl: "+format+"\n", obj...)
+	os.Exit(1)
+}
+
+// ctlClient makes requests to the control API.
+type ctlClient struct {
+	http *http.Client
+	base string
+}
+
+func newCtlClient(addr string) (*ctlClient, error) {
+	network, address, err := parseListenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		return &ctlClient{http: http.DefaultClient, base: "http://" + address}, nil
+	}
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", address)
+		},
+	}
+	return &ctlClient{http: &http.Client{Transport: tr}, base: "http://justrun"}, nil
+}
+
+// do makes a request to the API and copies the response to out, if it is
+// not nil. JSON responses are indented.
+func (c *ctlClient) do(method, path string, params url.Values, out io.Writer) error {
+	u := c.base + path
+	if len(params) != 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(apiHeader, "1")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach justrun: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s failed: %s", strings.TrimPrefix(path, "/"), strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	if resp.Header.Get("Content-Type") == "application/json" {
+		var v interface{}
+		err = json.NewDecoder(resp.Body).Decode(&v)
+		if err != nil {
+			return fmt.Errorf("unable to parse response: %s", err)
+		}
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", b)
+		return err
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
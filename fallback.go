@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// limitError is the OS refusing to watch more paths because a limit was
+// reached.
+type limitError struct {
+	err error
+}
+
+func (le *limitError) Error() string {
+	return fmt.Sprintf("%s (%s)", le.err, le.hint())
+}
+
+func (le *limitError) Unwrap() error {
+	return le.err
+}
+
+// hint says which limit was reached and how to raise it.
+func (le *limitError) hint() string {
+	switch {
+	case runtime.GOOS == "linux" && errors.Is(le.err, syscall.ENOSPC):
+		return "the limit on inotify watches was reached. Raise it with 'sudo sysctl fs.inotify.max_user_watches=524288', or use -poll-fallback to poll the paths over it"
+	case runtime.GOOS == "linux":
+		return "the limit on inotify instances or open files was reached. Raise it with 'sudo sysctl fs.inotify.max_user_instances=512' or 'ulimit -n', or use -poll-fallback to poll the paths over it"
+	}
+	return "the limit on open files was reached. Raise it with 'ulimit -n', or use -poll-fallback to poll the paths over it"
+}
+
+// isLimit returns true if err is from the OS running out of watches.
+func isLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE)
+}
+
+// explainLimit returns err as a *limitError if it is from the OS running
+// out of watches, and err otherwise.
+func explainLimit(err error) error {
+	if err == nil || !isLimit(err) {
+		return err
+	}
+	return &limitError{err: err}
+}
+
+// fallbackBackend watches paths with the OS's backend until the OS runs out
+// of watches, and the rest with a pollBackend.
+type fallbackBackend struct {
+	// native is the OS's backend. It is nil if it couldn't be created.
+	native backend
+	poll   *pollBackend
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	log    *slog.Logger
+	warn   sync.Once
+	closed sync.Once
+
+	mu sync.Mutex
+	// nativePaths and polled are the paths each backend watches.
+	nativePaths map[string]bool
+	polled      map[string]bool
+}
+
+// newFallbackBackend creates a fallbackBackend that polls at interval the
+// paths native can't watch, and warns log when it starts to. native may be
+// nil to poll every path.
+func newFallbackBackend(native backend, interval time.Duration, log *slog.Logger) *fallbackBackend {
+	fb := &fallbackBackend{
+		native:      native,
+		poll:        newPollBackend(interval),
+		events:      make(chan fsnotify.Event),
+		errors:      make(chan error),
+		done:        make(chan struct{}),
+		log:         log,
+		nativePaths: make(map[string]bool),
+		polled:      make(map[string]bool),
+	}
+	backends := []backend{fb.poll}
+	if native != nil {
+		backends = append(backends, native)
+	}
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		wg.Add(2)
+		go func(b backend) {
+			defer wg.Done()
+			for ev := range b.Events() {
+				select {
+				case fb.events <- ev:
+				case <-fb.done:
+				}
+			}
+		}(b)
+		go func(b backend) {
+			defer wg.Done()
+			for err := range b.Errors() {
+				select {
+				case fb.errors <- err:
+				case <-fb.done:
+				}
+			}
+		}(b)
+	}
+	go func() {
+		wg.Wait()
+		close(fb.events)
+		close(fb.errors)
+	}()
+	return fb
+}
+
+func (fb *fallbackBackend) Add(path string) error {
+	if fb.native != nil {
+		err := fb.native.Add(path)
+		if err == nil {
+			fb.mu.Lock()
+			fb.nativePaths[path] = true
+			fb.mu.Unlock()
+			return nil
+		}
+		if !isLimit(err) {
+			return err
+		}
+		fb.warn.Do(func() {
+			fb.log.Warn("the OS ran out of watches, polling the paths over the limit for changes", "err", explainLimit(err))
+		})
+	}
+	err := fb.poll.Add(path)
+	if err != nil {
+		return err
+	}
+	fb.mu.Lock()
+	fb.polled[path] = true
+	fb.mu.Unlock()
+	return nil
+}
+
+func (fb *fallbackBackend) Remove(path string) error {
+	fb.mu.Lock()
+	polled := fb.polled[path]
+	delete(fb.polled, path)
+	delete(fb.nativePaths, path)
+	fb.mu.Unlock()
+	if polled || fb.native == nil {
+		return fb.poll.Remove(path)
+	}
+	return fb.native.Remove(path)
+}
+
+func (fb *fallbackBackend) Events() <-chan fsnotify.Event { return fb.events }
+func (fb *fallbackBackend) Errors() <-chan error          { return fb.errors }
+
+func (fb *fallbackBackend) Close() error {
+	fb.closed.Do(func() { close(fb.done) })
+	fb.poll.Close()
+	if fb.native != nil {
+		return fb.native.Close()
+	}
+	return nil
+}
+
+// counts returns the number of paths watched by the OS and by polling.
+func (fb *fallbackBackend) counts() (native, polled int) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return len(fb.nativePaths), len(fb.polled)
+}
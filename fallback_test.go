@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExplainLimit(t *testing.T) {
+	err := explainLimit(fmt.Errorf("add: %w", syscall.ENOSPC))
+	var le *limitError
+	if !errors.As(err, &le) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("want a limitError wrapping ENOSPC, got %#v", err)
+	}
+	if runtime.GOOS == "linux" && !strings.Contains(err.Error(), "fs.inotify.max_user_watches") {
+		t.Errorf("want the sysctl to raise explained, got %#v", err.Error())
+	}
+	other := errors.New("permission denied")
+	if explainLimit(other) != other {
+		t.Errorf("other errors should be left alone")
+	}
+}
+
+// limitedBackend is a backend that runs out of watches after left paths
+// are added.
+type limitedBackend struct {
+	backend
+	left int
+}
+
+func (lb *limitedBackend) Add(path string) error {
+	if lb.left == 0 {
+		return fmt.Errorf("%q: %w", path, syscall.ENOSPC)
+	}
+	lb.left--
+	return lb.backend.Add(path)
+}
+
+func TestFallbackBackend(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	os.Mkdir(first, 0755)
+	os.Mkdir(second, 0755)
+	native := &limitedBackend{backend: newPollBackend(10 * time.Millisecond), left: 1}
+	fb := newFallbackBackend(native, 10*time.Millisecond, slog.Default())
+	defer fb.Close()
+	for _, d := range []string{first, second} {
+		err := fb.Add(d)
+		if err != nil {
+			t.Fatalf("unable to add %s: %s", d, err)
+		}
+	}
+	n, p := fb.counts()
+	if n != 1 || p != 1 {
+		t.Errorf("want 1 path watched natively and 1 polled, got %d and %d", n, p)
+	}
+
+	want := map[string]bool{
+		filepath.Join(first, "a"):  true,
+		filepath.Join(second, "b"): true,
+	}
+	for path := range want {
+		os.WriteFile(path, nil, 0666)
+	}
+	for len(want) != 0 {
+		select {
+		case ev := <-fb.Events():
+			delete(want, ev.Name)
+		case <-time.After(waitForMsg):
+			t.Fatalf("did not see events for %v", want)
+		}
+	}
+
+	err := fb.Remove(second)
+	if err != nil {
+		t.Errorf("unable to remove %s: %s", second, err)
+	}
+	if n, p = fb.counts(); n != 1 || p != 0 {
+		t.Errorf("after removing, want 1 and 0 paths, got %d and %d", n, p)
+	}
+}
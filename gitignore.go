@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitIgnorer is an Ignorer that ignores the paths git would ignore
+// according to the .gitignore files of the repositories the watched
+// paths are in and their .git/info/exclude files. The files are read
+// lazily and cached until invalidate is called for them.
+type gitIgnorer struct {
+	mu sync.Mutex
+	// repos is the set of repository root directories found so far.
+	repos map[string]bool
+	// files maps the path of an ignore file to its patterns. A nil
+	// patternIgnorer means the file does not exist.
+	files map[string]*patternIgnorer
+}
+
+func newGitIgnorer() *gitIgnorer {
+	return &gitIgnorer{
+		repos: make(map[string]bool),
+		files: make(map[string]*patternIgnorer),
+	}
+}
+
+// addRepoFor finds the repository the absolute path is in and returns the
+// directories holding the ignore files that apply to it, from the path's
+// directory up to the repository root. Those must be watched so that
+// changes to the ignore files are noticed. It returns nil if path is not
+// inside of a repository.
+func (gi *gitIgnorer) addRepoFor(path string) []string {
+	var dirs []string
+	dir := path
+	if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	for {
+		dirs = append(dirs, dir)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			gi.mu.Lock()
+			gi.repos[dir] = true
+			gi.mu.Unlock()
+			info := filepath.Join(dir, ".git", "info")
+			if fi, err := os.Stat(info); err == nil && fi.IsDir() {
+				dirs = append(dirs, info)
+			}
+			return dirs
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+func (gi *gitIgnorer) IsIgnored(path string) bool {
+	ignored := false
+	for _, pi := range gi.applicable(path) {
+		ig, matched := pi.match(path)
+		if matched {
+			ignored = ig
+		}
+	}
+	return ignored
+}
+
+func (gi *gitIgnorer) mayReinclude(dir string) bool {
+	for _, pi := range gi.applicable(filepath.Join(dir, "x")) {
+		if pi.mayReinclude(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidate drops the cached patterns for path if it is an ignore file.
+func (gi *gitIgnorer) invalidate(path string) {
+	if filepath.Base(path) != ".gitignore" && !strings.HasSuffix(path, filepath.Join(".git", "info", "exclude")) {
+		return
+	}
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	delete(gi.files, path)
+}
+
+// applicable returns the patterns that apply to path in increasing order
+// of precedence: the repository's exclude file, then the .gitignore files
+// from the repository root down to the path's directory.
+func (gi *gitIgnorer) applicable(path string) []*patternIgnorer {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	root := ""
+	for repo := range gi.repos {
+		if strings.HasPrefix(path, repo+string(filepath.Separator)) && len(repo) > len(root) {
+			root = repo
+		}
+	}
+	if root == "" {
+		return nil
+	}
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == root {
+			break
+		}
+	}
+	var pis []*patternIgnorer
+	if pi := gi.load(root, filepath.Join(root, ".git", "info", "exclude")); pi != nil {
+		pis = append(pis, pi)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if pi := gi.load(dirs[i], filepath.Join(dirs[i], ".gitignore")); pi != nil {
+			pis = append(pis, pi)
+		}
+	}
+	return pis
+}
+
+// load returns the cached patterns of the ignore file, reading it if
+// needed. Lines that are not valid patterns are skipped, like git does.
+// It must be called with gi.mu held.
+func (gi *gitIgnorer) load(base, file string) *patternIgnorer {
+	pi, ok := gi.files[file]
+	if ok {
+		return pi
+	}
+	f, err := os.Open(file)
+	if err == nil {
+		pi = &patternIgnorer{base: base}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			p, err := parseIgnorePattern(sc.Text())
+			if err == nil && p != nil {
+				pi.patterns = append(pi.patterns, p)
+			}
+		}
+		f.Close()
+	}
+	gi.files[file] = pi
+	return pi
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hasMeta returns true if p contains any of the characters that make it a
+// glob pattern instead of a literal file path.
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[`)
+}
+
+// matchGlob returns true if the slash-separated name matches pattern. A
+// pattern segment of "**" matches zero or more whole path segments. All
+// other segments are matched against a single path segment with the
+// semantics of path.Match.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for len(pat) > 1 && pat[1] == "**" {
+				pat = pat[1:]
+			}
+			if len(pat) == 1 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pat[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchGlobPrefix returns true if some path inside of the slash-separated
+// directory dir could match pattern.
+func matchGlobPrefix(pattern, dir string) bool {
+	pat := strings.Split(pattern, "/")
+	name := strings.Split(dir, "/")
+	for len(name) > 0 {
+		if len(pat) == 0 {
+			return false
+		}
+		if pat[0] == "**" {
+			return true
+		}
+		ok, err := path.Match(pat[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(pat) > 0
+}
+
+// globPattern is an input path that contains glob metacharacters. Events
+// are sent for any path matching it, including paths created after the
+// watch began.
+type globPattern struct {
+	// pattern is the absolute, slash-separated form of the user's
+	// pattern.
+	pattern string
+	// base is the longest leading directory of pattern that contains
+	// no metacharacters. It is the directory that must be watched in
+	// order to see the paths that match.
+	base string
+	// recursive is true if matching paths may be found in
+	// subdirectories of base.
+	recursive bool
+}
+
+func newGlobPattern(p string) (*globPattern, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, err
+	}
+	segs := strings.Split(filepath.ToSlash(abs), "/")
+	i := 0
+	for i < len(segs) && !hasMeta(segs[i]) {
+		i++
+	}
+	rest := segs[i:]
+	base := strings.Join(segs[:i], "/")
+	if base == "" {
+		base = "/"
+	}
+	g := &globPattern{
+		pattern:   strings.Join(segs, "/"),
+		base:      filepath.FromSlash(base),
+		recursive: len(rest) > 1 || (len(rest) == 1 && strings.Contains(rest[0], "**")),
+	}
+	// Check the pattern for syntax errors now instead of silently
+	// never matching anything later.
+	for _, seg := range rest {
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Match returns true if the absolute path matches the pattern.
+func (g *globPattern) Match(p string) bool {
+	return matchGlob(g.pattern, filepath.ToSlash(p))
+}
+
+// matchesHidden returns true if the pattern's final segment explicitly
+// asks for hidden files (e.g. "**/.env").
+func (g *globPattern) matchesHidden() bool {
+	return strings.HasPrefix(path.Base(g.pattern), ".")
+}
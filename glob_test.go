@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"/a/*.go", "/a/b.go", true},
+		{"/a/*.go", "/a/b/c.go", false},
+		{"/a/**/*.go", "/a/b.go", true},
+		{"/a/**/*.go", "/a/b/c/d.go", true},
+		{"/a/**/*.go", "/a/b/c/d.txt", false},
+		{"/a/**", "/a/b/c", true},
+		{"/a/**/**/c", "/a/c", true},
+		{"/a/templates/**/*.tmpl", "/a/templates/x/y.tmpl", true},
+		{"/a/templates/**/*.tmpl", "/a/other/y.tmpl", false},
+		{"/a/?.go", "/a/bb.go", false},
+		{"/a/[bc].go", "/a/c.go", true},
+	}
+	for _, tc := range tests {
+		got := matchGlob(tc.pattern, tc.name)
+		if got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %t, want %t", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
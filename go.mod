@@ -2,6 +2,7 @@ module github.com/jmhodges/justrun
 
 go 1.26.3
 
-require github.com/fsnotify/fsnotify v1.10.1
-
-require golang.org/x/sys v0.13.0 // indirect
+require (
+	github.com/fsnotify/fsnotify v1.10.1
+	golang.org/x/sys v0.13.0
+)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentHashes keeps the hashes of the contents of the watched files so
+// that writes that leave a file the same can be ignored.
+type contentHashes struct {
+	mu    sync.Mutex
+	files map[string]fileHash
+}
+
+// fileHash is the hash of a file's contents, and its size and modification
+// time when it was hashed. A file with the same size and modification time
+// is assumed to have the same contents without hashing it again, but only
+// if that modification time was a whole second before it was hashed.
+// Filesystems like NFS and the folders VMs share with their hosts keep
+// modification times in seconds, so a file changed again within the same
+// second keeps its modification time.
+type fileHash struct {
+	size   int64
+	mtime  time.Time
+	hashed time.Time
+	sum    [sha256.Size]byte
+}
+
+func newContentHashes() *contentHashes {
+	return &contentHashes{files: make(map[string]fileHash)}
+}
+
+// record hashes the contents of path, if it is a regular file, for later
+// calls to unchanged.
+func (ch *contentHashes) record(path string) {
+	now := time.Now()
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	fh, err := hashFile(path, fi, now)
+	if err != nil {
+		return
+	}
+	ch.mu.Lock()
+	ch.files[path] = fh
+	ch.mu.Unlock()
+}
+
+// forget drops the hashes of path and the files inside of it.
+func (ch *contentHashes) forget(path string) {
+	prefix := path + string(filepath.Separator)
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for p := range ch.files {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(ch.files, p)
+		}
+	}
+}
+
+// reset forgets every hash.
+func (ch *contentHashes) reset() {
+	ch.mu.Lock()
+	ch.files = make(map[string]fileHash)
+	ch.mu.Unlock()
+}
+
+// unchanged returns true if the contents of path are the same as when they
+// were last recorded. If they are not, the new contents are recorded.
+func (ch *contentHashes) unchanged(path string) bool {
+	now := time.Now()
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	ch.mu.Lock()
+	old, ok := ch.files[path]
+	ch.mu.Unlock()
+	if ok && old.settled() && old.size == fi.Size() && old.mtime.Equal(fi.ModTime()) {
+		return true
+	}
+	fh, err := hashFile(path, fi, now)
+	if err != nil {
+		return false
+	}
+	ch.mu.Lock()
+	ch.files[path] = fh
+	ch.mu.Unlock()
+	return ok && fh.sum == old.sum
+}
+
+// settled returns true if the file's modification time was a whole second
+// before it was hashed, so that any later change to it changes its
+// modification time.
+func (fh fileHash) settled() bool {
+	return fh.mtime.Before(fh.hashed.Truncate(time.Second))
+}
+
+// hashFile hashes the contents of the file at path, which had the info fi
+// at the time statted, before it was read.
+func hashFile(path string, fi os.FileInfo, statted time.Time) (fileHash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileHash{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return fileHash{}, err
+	}
+	fh := fileHash{size: fi.Size(), mtime: fi.ModTime(), hashed: statted}
+	h.Sum(fh.sum[:0])
+	return fh, nil
+}
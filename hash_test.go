@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContentHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foo.go")
+	os.WriteFile(path, []byte("package foo\n"), 0666)
+	ch := newContentHashes()
+	ch.record(path)
+
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	if !ch.unchanged(path) {
+		t.Errorf("touching the file changed it")
+	}
+	os.WriteFile(path, []byte("package foo\n"), 0666)
+	if !ch.unchanged(path) {
+		t.Errorf("writing the same contents changed it")
+	}
+	os.WriteFile(path, []byte("package bar\n"), 0666)
+	if ch.unchanged(path) {
+		t.Errorf("writing new contents didn't change it")
+	}
+	if !ch.unchanged(path) {
+		t.Errorf("the new contents weren't recorded")
+	}
+
+	// Filesystems that keep modification times in seconds can leave a
+	// file changed within the same second with the same size and
+	// modification time.
+	os.Chtimes(path, later, later)
+	ch.record(path)
+	os.WriteFile(path, []byte("package baz\n"), 0666)
+	os.Chtimes(path, later, later)
+	if ch.unchanged(path) {
+		t.Errorf("new contents with the same size and modification time didn't change it")
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+	ch.record(path)
+	if !ch.unchanged(path) {
+		t.Errorf("the same contents changed it")
+	}
+
+	ch.forget(filepath.Dir(path))
+	if ch.unchanged(path) {
+		t.Errorf("a forgotten file can't be unchanged")
+	}
+}
+
+func TestHashWatch(t *testing.T) {
+	fs := newFS(t)
+	fs.WriteFile("foobar", "same")
+	w, err := newWatcher(watcherOptions{hash: true})
+	if err != nil {
+		t.Fatalf("newWatcher: %s", err)
+	}
+	ch := make(chan event, 10)
+	err = w.addSet(watchConfig{inputPaths: []string{fs.Abs("foobar")}}, ch)
+	if err != nil {
+		t.Fatalf("addSet: %s", err)
+	}
+	w.start()
+	defer func() {
+		w.Close()
+		fs.Close()
+	}()
+	fs.WriteFile("foobar", "same")
+	seeNothing(fs, ch, "rewrite of foobar with the same contents")
+	// Like an editor's atomic save.
+	fs.WriteFile("foobar.tmp", "same")
+	fs.Rename("foobar.tmp", "foobar")
+	seeNothing(fs, ch, "rename of a copy of foobar over it")
+	fs.WriteFile("foobar", "different")
+	// The writes of the new contents may be seen as one or two events,
+	// depending on when the file is hashed.
+	select {
+	case <-ch:
+	case <-time.After(waitForMsg):
+		t.Errorf("did not see the new contents of foobar")
+	}
+}
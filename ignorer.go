@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -33,41 +36,298 @@ func (ug *userIgnorer) IsIgnored(path string) bool {
 	return false
 }
 
+// multiIgnorer is an Ignorer that ignores a path if any of the Ignorers
+// in it do.
+type multiIgnorer []Ignorer
+
+func (mi multiIgnorer) IsIgnored(path string) bool {
+	for _, ig := range mi {
+		if ig.IsIgnored(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayReinclude returns true if any of the Ignorers may include paths inside
+// of the ignored directory dir.
+func (mi multiIgnorer) mayReinclude(dir string) bool {
+	for _, ig := range mi {
+		if r, ok := ig.(reincluder); ok && r.mayReinclude(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// reincluder is implemented by Ignorers that can ignore a directory but
+// still include some of the paths inside of it. Directories they ignore
+// must still be watched if mayReinclude returns true for them.
+type reincluder interface {
+	mayReinclude(dir string) bool
+}
+
+// patternIgnorer is an Ignorer that uses patterns with the semantics of
+// gitignore files. Patterns without a slash match the file name of the
+// path or any of its parent directories, and patterns with one are
+// matched against the path relative to the base directory. Only the
+// former can match paths outside of the base directory. A leading
+// "!" re-includes paths ignored by an earlier pattern, a trailing "/"
+// only matches directories, and a pattern beginning with "re:" is a
+// regular expression matched against the relative path. The last pattern
+// to match a path decides whether it is ignored.
+type patternIgnorer struct {
+	base     string
+	patterns []*ignorePattern
+}
+
+type ignorePattern struct {
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	// glob is the slash-separated glob pattern, relative to the base
+	// directory. It is empty for regular expression patterns.
+	glob string
+	re   *regexp.Regexp
+}
+
+// createPatternIgnorer parses the given gitignore-style patterns. Blank
+// patterns and ones starting with "#" are skipped.
+func createPatternIgnorer(base string, patterns []string) (*patternIgnorer, error) {
+	pi := &patternIgnorer{base: base}
+	for _, line := range patterns {
+		p, err := parseIgnorePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			pi.patterns = append(pi.patterns, p)
+		}
+	}
+	return pi, nil
+}
+
+func parseIgnorePattern(line string) (*ignorePattern, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	p := &ignorePattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasPrefix(line, "re:") {
+		re, err := regexp.Compile(line[3:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern '%s': %s", line, err)
+		}
+		p.re = re
+		p.anchored = true
+		return p, nil
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, nil
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	for _, seg := range strings.Split(line, "/") {
+		if _, err := filepath.Match(seg, ""); err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern '%s': %s", line, err)
+		}
+	}
+	p.glob = line
+	return p, nil
+}
+
+func (pi *patternIgnorer) IsIgnored(path string) bool {
+	ignored, _ := pi.match(path)
+	return ignored
+}
+
+// match returns whether the path is ignored and whether any of the
+// patterns matched it at all.
+func (pi *patternIgnorer) match(path string) (ignored, matched bool) {
+	rel, inside := pi.rel(path)
+	isDir := -1
+	for _, p := range pi.patterns {
+		if (matched && p.negate != ignored) || (p.anchored && !inside) {
+			// This pattern can't change the outcome.
+			continue
+		}
+		if p.re != nil {
+			if p.re.MatchString(rel) {
+				ignored, matched = !p.negate, true
+			}
+			continue
+		}
+		if p.matchesParent(rel) {
+			ignored, matched = !p.negate, true
+			continue
+		}
+		if !matchGlob(p.glob, rel) {
+			continue
+		}
+		if p.dirOnly {
+			if isDir == -1 {
+				isDir = 0
+				if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+					isDir = 1
+				}
+			}
+			if isDir == 0 {
+				continue
+			}
+		}
+		ignored, matched = !p.negate, true
+	}
+	return ignored, matched
+}
+
+// matchesParent returns true if the pattern matches one of the parent
+// directories in the relative path rel.
+func (p *ignorePattern) matchesParent(rel string) bool {
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' && matchGlob(p.glob, rel[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pi *patternIgnorer) mayReinclude(dir string) bool {
+	rel, inside := pi.rel(dir)
+	for _, p := range pi.patterns {
+		if !p.negate || (p.anchored && !inside) {
+			continue
+		}
+		if p.re != nil || matchGlobPrefix(p.glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// rel returns the slash-separated path of path relative to the base
+// directory. If the path is not inside of it, the absolute path without
+// its leading slash and false are returned.
+func (pi *patternIgnorer) rel(path string) (string, bool) {
+	rel, err := filepath.Rel(pi.base, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return strings.TrimPrefix(filepath.ToSlash(path), "/"), false
+	}
+	return filepath.ToSlash(rel), true
+}
+
 // smartIgnorer is an Ignorer that looks at whether the file is a
 // dot-file and the user didn't ask to watch it specifically, or if it
-// was only included by justrun in order to track a child path the
-// user asked for. In either case, it will say that the path is
-// ignored.
+// is outside of the set of paths the user asked for (for instance,
+// because it was only watched by justrun in order to track a child
+// path the user asked for). In either case, it will say that the path
+// is ignored.
 type smartIgnorer struct {
 	includedHiddenFiles map[string]bool
-	ui                  *userIgnorer
+	// ui holds the Ignorers built from the user's ignored paths and
+	// ignore patterns.
+	ui multiIgnorer
+
+	// userPaths is the set of literal file and directory paths the
+	// user asked to be watched. Those paths and the direct children of
+	// the directories among them are in scope.
+	userPaths map[string]bool
 
-	// renameDirs is the set of directories that the user did not ask
-	// to be watched, but must be watched in order to check if a child
-	// is being renamed back in it.
-	renameDirs map[string]bool
+	// recursiveRoots are the directories the user asked to have
+	// watched recursively. Every path inside of them is in scope.
+	recursiveRoots []string
 
-	// renameChildren is the set of filepaths that the user asked to
-	// be watched and that are the direct children of the directories
-	// in renameDirs. That is, they are the direct children of
-	// directories that the user did not ask to watch but justrun must
-	// in order to catch renames. This map is used to make sure we
-	// only send an event when the exact files in renameDir they care
-	// about are added.
-	renameChildren map[string]bool
+	// globs are the glob patterns the user asked to be watched. Every
+	// path matching one of them is in scope.
+	globs []*globPattern
 }
 
 func (si *smartIgnorer) IsIgnored(path string) bool {
-	if si.ui.IsIgnored(path) {
-		return true
+	return si.isExcluded(path) || !si.inScope(path)
+}
+
+// ignoreReason returns why the path is ignored, or the empty string if it
+// is not.
+func (si *smartIgnorer) ignoreReason(path string) string {
+	for _, ig := range si.ui {
+		if !ig.IsIgnored(path) {
+			continue
+		}
+		switch ig.(type) {
+		case *userIgnorer:
+			return "ignored path"
+		case *patternIgnorer:
+			return "exclude pattern"
+		case *gitIgnorer:
+			return "ignored by git"
+		}
+		return "ignored"
+	}
+	if si.isHidden(path) {
+		return "hidden file"
 	}
+	if !si.inScope(path) {
+		return "not a watched path"
+	}
+	return ""
+}
+
+// isExcluded returns true if the path was ignored by the user or is a
+// hidden file they did not explicitly ask for.
+func (si *smartIgnorer) isExcluded(path string) bool {
+	return si.ui.IsIgnored(path) || si.isHidden(path)
+}
+
+// isHidden returns true if the path is a hidden file the user did not
+// explicitly ask for.
+func (si *smartIgnorer) isHidden(path string) bool {
 	baseName := filepath.Base(path)
-	if strings.HasPrefix(baseName, ".") && !si.includedHiddenFiles[path] {
+	if !strings.HasPrefix(baseName, ".") || si.includedHiddenFiles[path] {
+		return false
+	}
+	for _, g := range si.globs {
+		if g.matchesHidden() && g.Match(path) {
+			return false
+		}
+	}
+	return true
+}
+
+// canSkipDir returns true if nothing inside of dir could be unignored, so
+// there is no need to watch it.
+func (si *smartIgnorer) canSkipDir(dir string) bool {
+	if si.isHidden(dir) {
 		return true
 	}
-	dirPath := filepath.Dir(path)
-	if si.renameDirs[dirPath] && !si.renameChildren[path] {
+	return si.ui.IsIgnored(dir) && !si.ui.mayReinclude(dir)
+}
+
+func (si *smartIgnorer) inScope(path string) bool {
+	if si.userPaths[path] || si.userPaths[filepath.Dir(path)] {
 		return true
 	}
+	for _, root := range si.recursiveRoots {
+		if strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	for _, g := range si.globs {
+		if g.Match(path) {
+			return true
+		}
+	}
 	return false
 }
@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestPatternIgnorer(t *testing.T) {
+	pi, err := createPatternIgnorer("/base", []string{
+		"# a comment",
+		"*.swp",
+		"*~",
+		"4913",
+		"*_test.go",
+		"/bin/**",
+		"!bin/keep",
+		"build/",
+		"!build/important.txt",
+		"re:^gen/.*\\.pb\\.go$",
+	})
+	if err != nil {
+		t.Fatalf("unable to create patternIgnorer: %s", err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/base/main.go", false},
+		{"/base/.main.go.swp", true},
+		{"/base/sub/dir/main.go~", true},
+		{"/base/sub/4913", true},
+		{"/base/sub/foo_test.go", true},
+		{"/base/bin/justrun", true},
+		{"/base/bin/keep", false},
+		{"/base/sub/bin/justrun", false},
+		{"/base/build/out.o", true},
+		{"/base/build/important.txt", false},
+		{"/base/gen/foo.pb.go", true},
+		{"/base/gen/foo.go", false},
+		{"/elsewhere/foo.swp", true},
+		{"/elsewhere/bin/justrun", false},
+	}
+	for _, tc := range tests {
+		got := pi.IsIgnored(tc.path)
+		if got != tc.want {
+			t.Errorf("IsIgnored(%q) = %t, want %t", tc.path, got, tc.want)
+		}
+	}
+
+	if !pi.mayReinclude("/base/bin") {
+		t.Errorf("mayReinclude(\"/base/bin\") = false, want true")
+	}
+	if pi.mayReinclude("/base/sub") {
+		t.Errorf("mayReinclude(\"/base/sub\") = true, want false")
+	}
+}
+
+func TestIgnoreReason(t *testing.T) {
+	ui, err := createUserIgnorer([]string{"/base/bin"})
+	if err != nil {
+		t.Fatalf("unable to create userIgnorer: %s", err)
+	}
+	pi, err := createPatternIgnorer("/base", []string{"*.swp"})
+	if err != nil {
+		t.Fatalf("unable to create patternIgnorer: %s", err)
+	}
+	si := &smartIgnorer{
+		ui:             multiIgnorer{ui, pi},
+		userPaths:      map[string]bool{"/base": true},
+		recursiveRoots: []string{"/base"},
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/base/main.go", ""},
+		{"/base/bin/justrun", "ignored path"},
+		{"/base/main.go.swp", "exclude pattern"},
+		{"/base/.env", "hidden file"},
+		{"/elsewhere/main.go", "not a watched path"},
+	}
+	for _, tc := range tests {
+		got := si.ignoreReason(tc.path)
+		if got != tc.want {
+			t.Errorf("ignoreReason(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+		if si.IsIgnored(tc.path) != (got != "") {
+			t.Errorf("ignoreReason(%q) disagrees with IsIgnored", tc.path)
+		}
+	}
+}
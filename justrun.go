@@ -5,7 +5,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"strings"
@@ -15,15 +15,42 @@ import (
 )
 
 var (
-	help           = flag.Bool("help", false, "print this help text")
-	h              = flag.Bool("h", false, "print this help text")
-	command        = flag.String("c", "", "command to run when files change in given directories")
-	shell          = flag.String("s", "sh", "shell to run the command")
-	ignoreFlag     pathsFlag
-	stdin          = flag.Bool("stdin", false, "read list of files to track from stdin, not the command-line")
-	waitForCommand = flag.Bool("w", false, "wait for the command to finish and do not attempt to kill it")
-	delayDur       = flag.Duration("delay", 750*time.Millisecond, "the time to wait between runs of the command if many fs events occur")
-	verbose        = flag.Bool("v", false, "verbose output")
+	help            = flag.Bool("help", false, "print this help text")
+	h               = flag.Bool("h", false, "print this help text")
+	command         = flag.String("c", "", "command to run when files change in given directories")
+	shell           = flag.String("s", "sh", "shell to run the command")
+	ignoreFlag      pathsFlag
+	excludeFlag     patternsFlag
+	signalOnFlag    patternsFlag
+	opsFlag         patternsFlag
+	restartFlag     restartPolicy
+	stopSignal      = signalValue{syscall.SIGTERM}
+	forwardSignals  = flag.Bool("forward-signals", false, "send the signal justrun receives to the command instead of the -signal one, and pass SIGHUP, SIGUSR1, and SIGUSR2 on to it")
+	stdin           = flag.Bool("stdin", false, "read list of files to track from stdin, not the command-line")
+	waitForCommand  = flag.Bool("w", false, "wait for the command to finish and do not attempt to kill it")
+	delayDur        = flag.Duration("delay", 750*time.Millisecond, "the minimum time between the starts of two runs of the command")
+	debounceDur     = flag.Duration("debounce", 100*time.Millisecond, "the time to wait for fs events to stop before running the command")
+	maxWaitDur      = flag.Duration("max-wait", 5*time.Second, "the longest a steady stream of fs events may put off running the command (0 means forever)")
+	killAfterDur    = flag.Duration("kill-after", 0, "the time to wait after terminating the command before sending SIGKILL to it (0 means never)")
+	verbose         = flag.Bool("v", false, "verbose output, including debug messages about every fs event")
+	summaryFlag     = flag.Bool("summary", false, "add the number of runs that passed and failed and their average time to the banner written after each run")
+	logFormat       = flag.String("log-format", "text", "the format of justrun's log messages, 'text' or 'json'")
+	configPath      = flag.String("config", "", "the config file to read instead of the first "+configFileName+" found in the current directory or its parents")
+	hashFlag        = flag.Bool("hash", false, "ignore writes that leave a file's contents the same, by keeping a hash of the contents of every watched file")
+	eventBuffer     = flag.Uint("event-buffer", 0, "the number of fs events reported by the OS that may wait for justrun to handle them")
+	pollFallbackDur = flag.Duration("poll-fallback", 0, "when the OS runs out of watches, poll the paths over the limit for changes at this interval instead of exiting (0 means exit)")
+	pollDur         = flag.Duration("poll", 0, "poll the watched paths for changes at this interval instead of relying on the OS to report them, for NFS, FUSE, and folders shared with VMs and containers (0 means never)")
+	recursive       = flag.Bool("r", false, "watch the subdirectories of the given directories, including ones created later")
+	gitignore       = flag.Bool("gitignore", false, "ignore the paths that git ignores")
+	changedFileFlag = flag.String("changed-file", "", "a file to write the paths that changed since the last run to before each run")
+	substFlag       = flag.Bool("subst", false, "replace {} in the command with the paths that changed since the last run")
+	readyFlag       = flag.String("ready", "", "how to tell a run of the command is ready: 'tcp:HOST:PORT' for a port accepting connections, an http:// or https:// URL returning a 2xx status, or 'stdout:REGEX' for a line of output")
+	eventsFlag      = flag.String("events", "", "the format of the records of what justrun does to write, one per line. Only 'json' is supported")
+	eventsFileFlag  = flag.String("events-file", "", "the file, or 'fd:N' for a file descriptor, to write -events records to instead of stderr")
+	proxyFlag       = flag.String("proxy", "", "an address, like ':8080', to serve a reverse proxy to -upstream on that holds requests while the command restarts")
+	upstreamFlag    = flag.String("upstream", "", "the address of the server the command runs, like ':8081', for -proxy to forward requests to")
+	liveReloadFlag  = flag.String("livereload", "", "an address, like 'localhost:35729', to serve a script that reloads pages after each run on")
+	listenFlag      = flag.String("listen", "", "the unix socket path or localhost address to serve the control API on, like 'unix:justrun.sock' or 'localhost:8123'")
 )
 
 func usage() {
@@ -38,97 +65,264 @@ func argError(format string, obj ...interface{}) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		ctlMain(os.Args[2:])
+		return
+	}
 	flag.Var(&ignoreFlag, "i", "a file path to ignore events from (may be given multiple times)")
+	flag.Var(&stopSignal, "signal", "the signal sent to the command to stop it")
+	flag.Var(&signalOnFlag, "signal-on", "a signal and a gitignore-style pattern separated by a colon, like 'HUP:*.conf'. When only paths matching the pattern change, the signal is sent to the running command instead of rerunning it (may be given multiple times)")
+	flag.Var(&restartFlag, "restart", "when to restart the command after it exits on its own: 'never', 'on-failure', or 'always'. Restarts back off exponentially until a file changes")
+	flag.Var(&opsFlag, "ops", "the comma-separated fsnotify ops that cause the command to run, out of create, write, remove, rename, and chmod, optionally followed by a colon and a gitignore-style pattern of the paths they apply to, like 'create,remove:*.lock' (may be given multiple times)")
+	flag.Var(&excludeFlag, "x", "a gitignore-style pattern of paths to ignore events from, or a regular expression prefixed with 're:' (may be given multiple times)")
 	flag.Usage = usage
 	flag.Parse()
 	if *help || *h {
 		argError("help requested")
 	}
-	if len(*command) == 0 {
-		argError("no command given with -c")
+	cfg, err := readConfig(*configPath)
+	if err != nil {
+		argError("%s", err)
 	}
-	if *stdin && len(flag.Args()) != 0 {
-		argError("expected files to come in over stdin, but got paths '%s' in the commandline", strings.Join(flag.Args(), ", "))
+	set := flagsSet(flag.CommandLine)
+	if cfg != nil {
+		cfg.applyToFlags(set)
 	}
-	var inputPaths []string
-	if *stdin {
-		sc := bufio.NewScanner(os.Stdin)
-		for sc.Scan() {
-			inputPaths = append(inputPaths, sc.Text())
+	logger, err := newLogger(os.Stderr, *logFormat, *verbose)
+	if err != nil {
+		argError("%s", err)
+	}
+	slog.SetDefault(logger)
+	var lifecycle *lifecycleLog
+	if *eventsFlag != "" {
+		lifecycle, err = openLifecycleLog(*eventsFlag, *eventsFileFlag)
+		if err != nil {
+			argError("%s", err)
 		}
-		if sc.Err() != nil {
-			argError("error reading from stdin: %s", sc.Err())
+	}
+	var rules []*rule
+	if cfg != nil && len(cfg.Rules) != 0 && !set["c"] {
+		if *stdin || len(flag.Args()) != 0 {
+			argError("paths to watch may not be given when the config file has rules")
+		}
+		if *proxyFlag != "" || *upstreamFlag != "" {
+			argError("the proxy must be set up on a rule when the config file has rules")
+		}
+		// -x patterns are relative to the current directory, but the
+		// rules' patterns are relative to the config file's.
+		if len(excludeFlag) != 0 {
+			argError("ignore patterns must be given in the config file's exclude setting when it has rules")
+		}
+		for _, rc := range cfg.Rules {
+			r, err := cfg.newRule(rc, logger, lifecycle)
+			if err != nil {
+				argError("%s", err)
+			}
+			rules = append(rules, r)
 		}
 	} else {
-		inputPaths = flag.Args()
-	}
+		if len(*command) == 0 {
+			argError("no command given with -c")
+		}
+		if *stdin && len(flag.Args()) != 0 {
+			argError("expected files to come in over stdin, but got paths '%s' in the commandline", strings.Join(flag.Args(), ", "))
+		}
+		var inputPaths []string
+		if *stdin {
+			sc := bufio.NewScanner(os.Stdin)
+			for sc.Scan() {
+				inputPaths = append(inputPaths, sc.Text())
+			}
+			if sc.Err() != nil {
+				argError("error reading from stdin: %s", sc.Err())
+			}
+		} else {
+			inputPaths = flag.Args()
+		}
+		if len(inputPaths) == 0 && !*stdin && cfg != nil {
+			inputPaths = cfg.Paths
+		}
 
-	if len(inputPaths) == 0 {
-		argError("no file paths provided to watch")
-	}
+		if len(inputPaths) == 0 {
+			argError("no file paths provided to watch")
+		}
 
-	cmd := &cmdReloader{
-		cond:           &sync.Cond{L: new(sync.Mutex)},
-		command:        *command,
-		shell:          *shell,
-		waitForCommand: *waitForCommand,
+		wc := watchConfig{
+			inputPaths:     inputPaths,
+			ignoredPaths:   ignoreFlag,
+			ignorePatterns: excludeFlag,
+			gitignore:      *gitignore,
+			recursive:      *recursive,
+			ops:            opsFlag,
+		}
+		if len(excludeFlag) == 0 && cfg != nil {
+			wc.ignorePatterns = cfg.Exclude
+			wc.patternBase = cfg.dir
+		}
+		r, err := newRule(&ruleConfig{Command: *command}, wc, logger, lifecycle)
+		if err != nil {
+			argError("%s", err)
+		}
+		rules = append(rules, r)
 	}
 
 	sigCh := make(chan os.Signal, 10)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	go waitForInterrupt(sigCh, cmd)
+	if *forwardSignals {
+		signal.Notify(sigCh, syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	}
 
-	cmdCh := make(chan event, 100)
-	_, err := watch(inputPaths, ignoreFlag, cmdCh)
+	// All of the rules share one watcher.
+	w, err := newWatcher(watcherOptions{poll: *pollDur, pollFallback: *pollFallbackDur, buffer: *eventBuffer, hash: *hashFlag, log: logger, lifecycle: lifecycle})
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	if *pollDur > 0 {
+		slog.Info("polling for changes", "interval", *pollDur)
+	}
+	for _, r := range rules {
+		err = w.addSet(r.wc, r.cmdCh)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if native, polled, ok := w.backendCounts(); ok && polled > 0 {
+		slog.Warn("some paths are polled for changes", "watched_by_os", native, "polled", polled)
 	}
 
-	wasDelayed := false
+	var api *apiServer
+	if *listenFlag != "" {
+		api, err = listenAPI(*listenFlag, rules, w, logger)
+		if err != nil {
+			fatal(err)
+		}
+	}
 
-	lastStartTime := time.Now()
-	cmd.Reload()
-	tick := time.NewTicker(*delayDur)
-	for {
-		select {
-		case ev, ok := <-cmdCh:
-			if !ok {
-				return
-			}
-			if lastStartTime.After(ev.Time) {
-				continue
-			}
-			// Using delayDur here and in NewTicker is slightly semantically
-			// incorrect, but it simplifies our config and prevents the
-			// egregious reloading.
-			if time.Now().Sub(ev.Time) < *delayDur {
-				wasDelayed = true
-				continue
-			}
-			wasDelayed = false
-			lastStartTime = time.Now()
-			cmd.Reload()
-			tick.Stop()
-			tick = time.NewTicker(*delayDur)
-		case <-tick.C:
-			if wasDelayed {
-				wasDelayed = false
-				lastStartTime = time.Now()
-				cmd.Reload()
-			}
+	var proxies []*proxy
+	for _, r := range rules {
+		if r.proxyAddr == "" {
+			continue
 		}
+		p, err := listenProxy(r.proxyAddr, r.upstream, logger)
+		if err != nil {
+			fatal(err)
+		}
+		r.cmd.onChange(p.onChange)
+		proxies = append(proxies, p)
+		r.cmd.log.Info("proxying requests", "addr", r.proxyAddr, "upstream", p.upstream)
+	}
+
+	var lr *liveReloader
+	if *liveReloadFlag != "" {
+		lr, err = listenLiveReload(*liveReloadFlag, logger)
+		if err != nil {
+			fatal(err)
+		}
+		for _, r := range rules {
+			// Commands with a readiness check cause reloads once
+			// they're ready, and others once they succeed or have
+			// kept running for a while.
+			r.cmd.onChange(lr.follow(r.cmd.ready != nil || r.proxyAddr != ""))
+		}
+		slog.Info("serving live reload script", "url", "http://"+lr.ln.Addr().String()+"/livereload.js")
 	}
+
+	// The keyboard controls are only set up after the last chance of a
+	// fatal error so that the terminal is always restored, either here
+	// or when justrun is interrupted.
+	var kb *keyboard
+	if !*stdin && isTerminal(os.Stdin) && isForeground(os.Stdin) {
+		kb, err = newKeyboard(os.Stdin, rules, sigCh, logger)
+		if err != nil {
+			slog.Warn("keyboard controls are disabled", "err", err)
+		}
+	}
+	defer kb.restore()
+	go waitForInterrupt(sigCh, rules, func() {
+		kb.restore()
+		api.Close()
+		lr.Close()
+		for _, p := range proxies {
+			p.Close()
+		}
+	})
+
+	w.start()
+	if api != nil {
+		go api.serve()
+	}
+	for _, p := range proxies {
+		go p.serve()
+	}
+	if lr != nil {
+		go lr.serve()
+	}
+	if kb != nil {
+		slog.Info("press ? to list the keyboard controls")
+		go kb.listen()
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range rules {
+		wg.Add(1)
+		go func(r *rule) {
+			defer wg.Done()
+			r.run()
+		}(r)
+	}
+	wg.Wait()
 }
 
-func waitForInterrupt(sigCh chan os.Signal, cmd *cmdReloader) {
-	for range sigCh {
+// waitForInterrupt terminates the rules' commands, calls cleanup, and exits
+// when justrun is asked to stop. When forwarding signals, the commands are
+// terminated with the signal justrun received, and the signals that don't
+// stop justrun are passed on to them.
+func waitForInterrupt(sigCh chan os.Signal, rules []*rule, cleanup func()) {
+	for s := range sigCh {
+		sig := s.(syscall.Signal)
+		switch sig {
+		case syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2:
+			for _, r := range rules {
+				r.cmd.Signal(sig)
+			}
+			continue
+		}
 		go func() {
-			cmd.Terminate()
+			var wg sync.WaitGroup
+			for _, r := range rules {
+				wg.Add(1)
+				go func(r *rule) {
+					defer wg.Done()
+					if *forwardSignals {
+						r.cmd.TerminateWith(sig)
+					} else {
+						r.cmd.Terminate()
+					}
+				}(r)
+			}
+			wg.Wait()
+			cleanup()
 			os.Exit(0)
 		}()
 	}
 }
 
+// fatal logs err and exits.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
+
+// flagsSet returns the set of the names of the flags in fs that were given
+// on the commandline.
+func flagsSet(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
 type pathsFlag []string
 
 func (pf *pathsFlag) String() string {
@@ -152,3 +346,20 @@ func (pf *pathsFlag) Set(value string) error {
 	}
 	return nil
 }
+
+// patternsFlag is like pathsFlag, but does not split on commas because they
+// may be part of a pattern.
+type patternsFlag []string
+
+func (pf *patternsFlag) String() string {
+	return fmt.Sprint(*pf)
+}
+
+func (pf *patternsFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return errors.New("a pattern may not be blank")
+	}
+	*pf = append(*pf, value)
+	return nil
+}
@@ -4,11 +4,14 @@ package main
 
 import (
 	"crypto/rand"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 const waitForMsg = 2 * time.Second
@@ -189,6 +192,206 @@ func TestHiddenFilesHiddenByDefault(t *testing.T) {
 	seeNothing(fs, ch, "no event for changes to hDir3/.hiddenAndIgnored")
 }
 
+func TestRecursiveWatch(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("rDir/level1")
+	ch := make(chan event, 10)
+	cleanUp := watchConfigTest(fs, watchConfig{inputPaths: []string{fs.Abs(".")}, recursive: true}, ch)
+	defer cleanUp()
+
+	fs.Create("rDir/level1/foobar")
+	seeCreation(fs, ch, "rDir/level1/foobar")
+	fs.MkdirAll("rDir/newDir")
+	seeCreation(fs, ch, "rDir/newDir")
+	fs.Create("rDir/newDir/foobar")
+	seeCreation(fs, ch, "rDir/newDir/foobar")
+}
+
+// Slow in the success case
+func TestRecursiveWatchIgnoresDirs(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("ignoredDir/level1")
+	fs.MkdirAll(".hiddenDir/level1")
+	ch := make(chan event, 10)
+	wc := watchConfig{
+		inputPaths:   []string{fs.Abs(".")},
+		ignoredPaths: []string{fs.Abs("ignoredDir")},
+		recursive:    true,
+	}
+	cleanUp := watchConfigTest(fs, wc, ch)
+	defer cleanUp()
+
+	fs.Create("ignoredDir/level1/foobar")
+	fs.Create(".hiddenDir/level1/foobar")
+	fs.MkdirAll("ignoredDir/newDir")
+	fs.Create("ignoredDir/newDir/foobar")
+	seeNothing(fs, ch, "creations in ignored and hidden dirs")
+}
+
+func TestGlobWatch(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("gDir/level1")
+	ch := make(chan event, 10)
+	cleanUp := watchTest(fs, []string{fs.Abs("**/*.go")}, nil, ch)
+	defer cleanUp()
+
+	fs.Create("gDir/level1/foo.txt")
+	seeNothing(fs, ch, "creation of non-matching file")
+	fs.MkdirAll("gDir/newDir")
+	fs.Create("gDir/newDir/foo.go")
+	seeCreation(fs, ch, "gDir/newDir/foo.go")
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("bin")
+	ch := make(chan event, 10)
+	wc := watchConfig{
+		inputPaths:     []string{fs.Abs(".")},
+		ignorePatterns: []string{"*.swp", "bin/", "!keep"},
+		recursive:      true,
+	}
+	cleanUp := watchConfigTest(fs, wc, ch)
+	defer cleanUp()
+
+	fs.Create("foo.swp")
+	fs.Create("bin/justrun")
+	seeNothing(fs, ch, "creation of ignored files")
+	fs.Create("bin/keep")
+	seeCreation(fs, ch, "bin/keep")
+}
+
+func TestGitignore(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll(".git/info")
+	fs.MkdirAll("build")
+	fs.WriteFile(".gitignore", "build/\n*.log\n")
+	ch := make(chan event, 10)
+	wc := watchConfig{
+		inputPaths: []string{fs.Abs(".")},
+		gitignore:  true,
+		recursive:  true,
+	}
+	cleanUp := watchConfigTest(fs, wc, ch)
+	defer cleanUp()
+
+	fs.Create("build/out")
+	fs.Create("foo.log")
+	seeNothing(fs, ch, "creation of gitignored files")
+	fs.WriteFile(".gitignore", "build/\n")
+	fs.Create("bar.log")
+	seeCreation(fs, ch, "bar.log")
+}
+
+func TestSharedWatcher(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("protoDir")
+	fs.MkdirAll("webDir")
+	protoCh := make(chan event, 10)
+	webCh := make(chan event, 10)
+	w, err := newWatcher(watcherOptions{})
+	if err != nil {
+		t.Fatalf("unable to create watcher: %s", err)
+	}
+	defer fs.Close()
+	defer w.Close()
+	err = w.addSet(watchConfig{inputPaths: []string{fs.Abs("protoDir/*.proto")}}, protoCh)
+	if err != nil {
+		t.Fatalf("unable to add proto watch: %s", err)
+	}
+	err = w.addSet(watchConfig{inputPaths: []string{fs.Abs("webDir")}, recursive: true}, webCh)
+	if err != nil {
+		t.Fatalf("unable to add web watch: %s", err)
+	}
+	w.start()
+
+	fs.Create("protoDir/foo.proto")
+	seeCreation(fs, protoCh, "protoDir/foo.proto")
+	seeNothing(fs, webCh, "creation of protoDir/foo.proto in web rule")
+	fs.Create("webDir/foo.js")
+	seeCreation(fs, webCh, "webDir/foo.js")
+	seeNothing(fs, protoCh, "creation of webDir/foo.js in proto rule")
+}
+
+func TestBlockedRule(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("slowDir")
+	fs.MkdirAll("fastDir")
+	// Nothing takes the slow rule's events, like while it waits for a
+	// long run of its command.
+	slowCh := make(chan event)
+	fastCh := make(chan event, 10)
+	w, err := newWatcher(watcherOptions{})
+	if err != nil {
+		t.Fatalf("unable to create watcher: %s", err)
+	}
+	defer fs.Close()
+	defer w.Close()
+	err = w.addSet(watchConfig{inputPaths: []string{fs.Abs("slowDir")}}, slowCh)
+	if err != nil {
+		t.Fatalf("unable to add slow watch: %s", err)
+	}
+	err = w.addSet(watchConfig{inputPaths: []string{fs.Abs("fastDir")}}, fastCh)
+	if err != nil {
+		t.Fatalf("unable to add fast watch: %s", err)
+	}
+	w.start()
+
+	for _, name := range []string{"a", "b", "c"} {
+		fs.Create("slowDir/" + name)
+	}
+	fs.Create("fastDir/foo")
+	seeCreation(fs, fastCh, "fastDir/foo")
+	seeCreation(fs, slowCh, "slowDir/a")
+}
+
+func TestChangedFileIgnored(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("changedDir")
+	ch := make(chan event, 10)
+	wc := watchConfig{
+		inputPaths:  []string{fs.Abs("changedDir")},
+		changedFile: fs.Abs("changedDir/changed.txt"),
+	}
+	cleanUp := watchConfigTest(fs, wc, ch)
+	defer cleanUp()
+	fs.Create("changedDir/foo.go")
+	seeCreation(fs, ch, "changedDir/foo.go")
+	// The run for foo.go writes the changed file.
+	err := writeChangedFile(wc.changedFile, []string{fs.Abs("changedDir/foo.go")})
+	if err != nil {
+		t.Fatalf("unable to write changed file: %s", err)
+	}
+	seeNothing(fs, ch, "writing the changed file")
+}
+
+func TestFailedUpdateSet(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("oldDir")
+	fs.MkdirAll("newDir")
+	w, err := newWatcher(watcherOptions{})
+	if err != nil {
+		t.Fatalf("unable to create watcher: %s", err)
+	}
+	defer fs.Close()
+	defer w.Close()
+	ch := make(chan event, 10)
+	err = w.addSet(watchConfig{inputPaths: []string{fs.Abs("oldDir")}}, ch)
+	if err != nil {
+		t.Fatalf("addSet: %s", err)
+	}
+	wc := watchConfig{inputPaths: []string{fs.Abs("newDir"), fs.Abs("missing")}}
+	err = w.updateSet(wc, ch)
+	if err == nil {
+		t.Fatalf("want an error for a missing path")
+	}
+	for _, path := range w.fs.(*notifyBackend).w.WatchList() {
+		if path == fs.Abs("newDir") {
+			t.Errorf("newDir is still watched after the update failed")
+		}
+	}
+}
+
 func renameTest(fs *fileSystem, ch <-chan event, oldpath, newpath string) {
 	fs.Rename(oldpath, newpath)
 	seeRename(fs, ch, oldpath, newpath)
@@ -239,7 +442,11 @@ func seeChangeContents(fs *fileSystem, ch <-chan event, path string) {
 }
 
 func watchTest(fs *fileSystem, inputPaths, ignoredPaths []string, cmdCh chan<- event) func() {
-	w, err := watch(inputPaths, ignoredPaths, cmdCh)
+	return watchConfigTest(fs, watchConfig{inputPaths: inputPaths, ignoredPaths: ignoredPaths}, cmdCh)
+}
+
+func watchConfigTest(fs *fileSystem, wc watchConfig, cmdCh chan<- event) func() {
+	w, err := watch(wc, cmdCh)
 	if err != nil {
 		fs.t.Fatalf("unable to run watch: %#v", err)
 		return func() {}
@@ -278,6 +485,14 @@ func (fs *fileSystem) Create(path string) {
 	f.Close()
 }
 
+func (fs *fileSystem) WriteFile(path, contents string) {
+	fullName := filepath.Join(fs.name, path)
+	err := os.WriteFile(fullName, []byte(contents), 0666)
+	if err != nil {
+		fs.t.Fatalf("unable to write '%s' in '%s': %#v", path, fs.name, err)
+	}
+}
+
 func (fs *fileSystem) MkdirAll(path string) {
 	fullName := filepath.Join(fs.name, path)
 	err := os.MkdirAll(fullName, 0700)
@@ -331,3 +546,68 @@ func (fs *fileSystem) Close() {
 		fs.t.Fatalf("unable to delete directory '%s'", fs.name)
 	}
 }
+
+// overflowBackend is a pollBackend whose errors can be sent by tests.
+type overflowBackend struct {
+	*pollBackend
+	errs chan error
+}
+
+func (ob *overflowBackend) Errors() <-chan error { return ob.errs }
+
+func TestEventOverflow(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("root")
+	fs.WriteFile("root/foo", "a")
+	ob := &overflowBackend{pollBackend: newPollBackend(time.Hour), errs: make(chan error)}
+	w := &watcher{fs: ob, log: slog.Default(), hashes: newContentHashes(), dirs: make(map[string]bool)}
+	ch := make(chan event, 10)
+	err := w.addSet(watchConfig{inputPaths: []string{fs.Abs("root")}, recursive: true}, ch)
+	if err != nil {
+		t.Fatalf("addSet: %s", err)
+	}
+	w.start()
+	defer func() {
+		w.Close()
+		fs.Close()
+	}()
+
+	// The events for this are never seen because the backend only polls
+	// once an hour.
+	fs.MkdirAll("root/missed")
+	fs.WriteFile("root/foo", "b")
+	ob.errs <- fsnotify.ErrEventOverflow
+	select {
+	case ev := <-ch:
+		if !ev.lost() {
+			t.Errorf("want a lost event, got %v", ev.Event)
+		}
+	case <-time.After(waitForMsg):
+		t.Fatalf("no event sent after the overflow")
+	}
+	w.mu.Lock()
+	watched := w.dirs[fs.Abs("root/missed")]
+	w.mu.Unlock()
+	if !watched {
+		t.Errorf("directory created during the overflow was not watched after it")
+	}
+	// The run for the lost event saw the new contents, so going back
+	// to the old ones is a change.
+	fs.WriteFile("root/foo", "a")
+	if w.hashes.unchanged(fs.Abs("root/foo")) {
+		t.Errorf("the hash from before the overflow was kept")
+	}
+}
+
+func TestOpsFilter(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("opsDir")
+	fs.Create("opsDir/foobar")
+	ch := make(chan event, 10)
+	cleanUp := watchConfigTest(fs, watchConfig{inputPaths: []string{fs.Abs("opsDir")}, ops: []string{"create"}}, ch)
+	defer cleanUp()
+	fs.ChangeContents("opsDir/foobar")
+	seeNothing(fs, ch, "write to opsDir/foobar")
+	fs.Create("opsDir/baz")
+	seeCreation(fs, ch, "opsDir/baz")
+}
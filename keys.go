@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// keyHelp describes the keys a keyboard responds to.
+const keyHelp = `keys:
+  r, enter  rerun the command
+  p         pause or resume rerunning the command as files change
+  k         stop the command without rerunning it
+  c         clear the screen
+  q         quit
+  ?         show this help
+`
+
+// keyboard controls the rules with single key presses on the terminal
+// justrun was started from.
+type keyboard struct {
+	in *os.File
+	// old is the state of the terminal to restore when justrun exits.
+	old    *unix.Termios
+	rules  []*rule
+	sigCh  chan<- os.Signal
+	log    *slog.Logger
+	paused bool
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlReadTermios)
+	return err == nil
+}
+
+// isForeground returns true if justrun's process group is the foreground
+// one of the terminal f. Changing the settings of the terminal from the
+// background, like after 'justrun ... &', gets justrun stopped with
+// SIGTTOU.
+func isForeground(f *os.File) bool {
+	pgrp, err := unix.IoctlGetInt(int(f.Fd()), unix.TIOCGPGRP)
+	return err == nil && pgrp == unix.Getpgrp()
+}
+
+// newKeyboard puts the terminal f into a mode where key presses are read
+// as they happen and not echoed. Quitting sends os.Interrupt to sigCh.
+func newKeyboard(f *os.File, rules []*rule, sigCh chan<- os.Signal, log *slog.Logger) (*keyboard, error) {
+	fd := int(f.Fd())
+	old, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the terminal's settings: %s", err)
+	}
+	// Only line buffering and echoing are turned off. Leaving the rest
+	// alone means ctrl-C still interrupts justrun and the command's
+	// output is printed as usual.
+	raw := *old
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	err = unix.IoctlSetTermios(fd, ioctlWriteTermios, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to change the terminal's settings: %s", err)
+	}
+	return &keyboard{in: f, old: old, rules: rules, sigCh: sigCh, log: log}, nil
+}
+
+// restore puts the terminal back the way it was. It does nothing if kb is
+// nil.
+func (kb *keyboard) restore() {
+	if kb == nil {
+		return
+	}
+	unix.IoctlSetTermios(int(kb.in.Fd()), ioctlWriteTermios, kb.old)
+}
+
+// listen handles key presses until the terminal is closed.
+func (kb *keyboard) listen() {
+	var b [1]byte
+	for {
+		n, err := kb.in.Read(b[:])
+		if err != nil || n == 0 {
+			return
+		}
+		kb.handle(b[0])
+	}
+}
+
+func (kb *keyboard) handle(key byte) {
+	switch key {
+	case 'r', '\r', '\n':
+		for _, r := range kb.rules {
+			r.rerun()
+		}
+	case 'p':
+		kb.paused = !kb.paused
+		if kb.paused {
+			kb.log.Info("paused, press p to resume")
+		} else {
+			kb.log.Info("resumed")
+		}
+		for _, r := range kb.rules {
+			r.setPaused(kb.paused)
+		}
+	case 'k':
+		// Stopping waits for the commands to exit, which mustn't hold
+		// up the other keys.
+		for _, r := range kb.rules {
+			go r.stop()
+		}
+	case 'c':
+		fmt.Fprint(os.Stdout, "\033[H\033[2J")
+	case 'q':
+		kb.sigCh <- os.Interrupt
+	case '?', 'h':
+		fmt.Fprint(os.Stderr, keyHelp)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The types of lifecycleRecords.
+const (
+	recWatch       = "watch"
+	recEventUsed   = "fs_event_accepted"
+	recEventIgnore = "fs_event_ignored"
+	recRunStart    = "run_started"
+	recRunReady    = "run_ready"
+	recRunTerm     = "run_terminated"
+	recRunExit     = "run_exited"
+	recWatchError  = "watch_error"
+	recEventsLost  = "fs_events_lost"
+)
+
+// lifecycleRecord is one thing that happened to justrun. Only the fields
+// that make sense for its type are set.
+type lifecycleRecord struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	Rule string    `json:"rule,omitempty"`
+
+	// Path and Op describe a watched path or fs event.
+	Path string `json:"path,omitempty"`
+	Op   string `json:"op,omitempty"`
+	// Reason says why an fs event was ignored.
+	Reason string `json:"reason,omitempty"`
+
+	Pid        int      `json:"pid,omitempty"`
+	Generation int      `json:"generation,omitempty"`
+	Changed    []string `json:"changed,omitempty"`
+	// Signal is the signal a run was terminated with or, for a run that
+	// exited, the one that killed it.
+	Signal   string  `json:"signal,omitempty"`
+	ExitCode *int    `json:"exit_code,omitempty"`
+	Duration float64 `json:"duration_seconds,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// lifecycleLog writes lifecycleRecords as JSON objects, one per line. A nil
+// *lifecycleLog writes nothing, for when -events isn't given.
+type lifecycleLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	// path is the absolute path of the file written to, if it is known.
+	path string
+}
+
+// openLifecycleLog creates a lifecycleLog in the given format that writes
+// to dest, which is a file path, "fd:N" for an open file descriptor, or
+// empty for stderr.
+func openLifecycleLog(format, dest string) (*lifecycleLog, error) {
+	if format != "json" {
+		return nil, fmt.Errorf("unknown events format '%s', only 'json' is supported", format)
+	}
+	var w io.Writer
+	path := ""
+	switch {
+	case dest == "":
+		w = os.Stderr
+	case strings.HasPrefix(dest, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(dest, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("'%s' is not a valid file descriptor", dest)
+		}
+		w = os.NewFile(uintptr(fd), dest)
+	default:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open events file: %s", err)
+		}
+		w = f
+		path, err = filepath.Abs(dest)
+		if err != nil {
+			return nil, errors.New("unable to get current working dir while opening the events file")
+		}
+	}
+	return &lifecycleLog{enc: json.NewEncoder(w), path: path}, nil
+}
+
+// emit writes rec, stamped with the current time. It does nothing if ll is
+// nil.
+func (ll *lifecycleLog) emit(rec lifecycleRecord) {
+	if ll == nil {
+		return
+	}
+	rec.Time = time.Now()
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	// Errors are dropped so that a reader going away doesn't stop the
+	// commands from being run.
+	ll.enc.Encode(rec)
+}
+
+// ownFile returns the path of the file ll writes to, or the empty string if
+// it is unknown or ll is nil. The file must not be watched, or every record
+// written would cause another.
+func (ll *lifecycleLog) ownFile() string {
+	if ll == nil {
+		return ""
+	}
+	return ll.path
+}
+
+// signalName returns the name signals are given by in flags.
+func signalName(sig syscall.Signal) string {
+	sv := signalValue{sig}
+	return sv.String()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The kinds of messages sent to live reload clients.
+const (
+	// reloadPage asks the clients to reload the page.
+	reloadPage = "reload"
+	// reloadCSS asks the clients to reload only their stylesheets.
+	reloadCSS = "css"
+)
+
+// liveReloadSettle is how long a run without a readiness check has to keep
+// running to be taken for a server that's up.
+const liveReloadSettle = time.Second
+
+// liveReloadJS is the script pages include to be reloaded after each run.
+// It finds the event stream next to where it was loaded from, and waits
+// for the page's server to answer before reloading it.
+const liveReloadJS = `(function() {
+  var src = document.currentScript.src;
+  var es = new EventSource(src.replace(/\.js(\?.*)?$/, ""));
+  function whenUp(f) {
+    fetch(location.href, {method: "HEAD", cache: "no-store"}).then(f, function() {
+      setTimeout(function() { whenUp(f); }, 250);
+    });
+  }
+  es.addEventListener("reload", function() {
+    whenUp(function() { location.reload(); });
+  });
+  es.addEventListener("css", function() {
+    var links = document.querySelectorAll('link[rel="stylesheet"]');
+    for (var i = 0; i < links.length; i++) {
+      var url = new URL(links[i].href);
+      url.searchParams.set("justrun", Date.now());
+      links[i].href = url.toString();
+    }
+  });
+})();
+`
+
+// liveReloader serves an event stream that tells browsers to reload the
+// page after the commands run, and the script that listens to it.
+type liveReloader struct {
+	ln net.Listener
+	// settle is how long a run without a readiness check has to keep
+	// running to cause a reload.
+	settle time.Duration
+	log    *slog.Logger
+
+	mu      sync.Mutex
+	clients map[chan liveReloadMsg]bool
+}
+
+// liveReloadMsg is a message sent to the live reload clients.
+type liveReloadMsg struct {
+	kind    string
+	changed []string
+}
+
+// listenLiveReload starts listening for live reload clients on addr.
+func listenLiveReload(addr string, log *slog.Logger) (*liveReloader, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on '%s': %s", addr, err)
+	}
+	lr := &liveReloader{
+		ln:      ln,
+		settle:  liveReloadSettle,
+		log:     log,
+		clients: make(map[chan liveReloadMsg]bool),
+	}
+	return lr, nil
+}
+
+// serve handles requests until the liveReloader is closed.
+func (lr *liveReloader) serve() {
+	err := http.Serve(lr.ln, lr.handler())
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		lr.log.Warn("live reload server stopped", "err", err)
+	}
+}
+
+// Close stops the liveReloader. It does nothing if lr is nil.
+func (lr *liveReloader) Close() error {
+	if lr == nil {
+		return nil
+	}
+	return lr.ln.Close()
+}
+
+func (lr *liveReloader) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livereload.js", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/javascript")
+		io.WriteString(rw, liveReloadJS)
+	})
+	mux.HandleFunc("/livereload", lr.handleEvents)
+	return mux
+}
+
+// handleEvents streams the messages broadcast to the client as
+// server-sent events until it goes away.
+func (lr *liveReloader) handleEvents(rw http.ResponseWriter, req *http.Request) {
+	ch := make(chan liveReloadMsg, 10)
+	lr.mu.Lock()
+	lr.clients[ch] = true
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, ch)
+		lr.mu.Unlock()
+	}()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	// The pages are served from the command's server, which is another
+	// origin.
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	rw.WriteHeader(http.StatusOK)
+	flusher, _ := rw.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case msg := <-ch:
+			data, err := json.Marshal(msg.changed)
+			if err != nil {
+				return
+			}
+			_, err = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", msg.kind, data)
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// broadcast sends msg to every client. Clients that have fallen behind miss
+// it rather than hold up the command.
+func (lr *liveReloader) broadcast(msg liveReloadMsg) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.log.Debug("telling live reload clients to reload", "kind", msg.kind, "clients", len(lr.clients))
+	for ch := range lr.clients {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// follow returns a func for cmdReloader.onChange that broadcasts a message
+// once for each run of the command. If onReady is true, that is when the
+// run becomes ready. Otherwise, runs have no readiness check, and it is
+// when they exit successfully or, like servers, are still running after
+// lr.settle. Runs for changes to stylesheets alone only reload the
+// clients' stylesheets.
+func (lr *liveReloader) follow(onReady bool) func(runChange) {
+	// mu guards the state of the current run, which the settle timers
+	// read too.
+	var mu sync.Mutex
+	var changed []string
+	gen := 0
+	stopping, exited, reloaded := false, false, false
+	reload := func() {
+		if !reloaded {
+			reloaded = true
+			lr.broadcast(newLiveReloadMsg(changed))
+		}
+	}
+	return func(c runChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch c.state {
+		case runStarted:
+			gen, changed = c.gen, c.changed
+			stopping, exited, reloaded = false, false, false
+		case runStopping:
+			stopping = true
+		case runReady:
+			if onReady {
+				reload()
+				return
+			}
+			time.AfterFunc(lr.settle, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if gen == c.gen && !stopping && !exited {
+					reload()
+				}
+			})
+		case runExited:
+			exited = true
+			if !onReady && !stopping && c.err == nil {
+				reload()
+			}
+		}
+	}
+}
+
+// newLiveReloadMsg returns the message to send after a run caused by
+// changes to the given paths.
+func newLiveReloadMsg(changed []string) liveReloadMsg {
+	kind := reloadPage
+	if len(changed) != 0 {
+		kind = reloadCSS
+		for _, p := range changed {
+			if filepath.Ext(p) != ".css" {
+				kind = reloadPage
+				break
+			}
+		}
+	}
+	return liveReloadMsg{kind: kind, changed: changed}
+}
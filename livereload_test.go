@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLiveReloadMsg(t *testing.T) {
+	tests := []struct {
+		changed []string
+		want    string
+	}{
+		{nil, reloadPage},
+		{[]string{"/a/site.css"}, reloadCSS},
+		{[]string{"/a/site.css", "/a/print.css"}, reloadCSS},
+		{[]string{"/a/site.css", "/a/index.html"}, reloadPage},
+	}
+	for _, tc := range tests {
+		got := newLiveReloadMsg(tc.changed).kind
+		if got != tc.want {
+			t.Errorf("%v: want %#v, got %#v", tc.changed, tc.want, got)
+		}
+	}
+}
+
+func TestLiveReload(t *testing.T) {
+	lr, err := listenLiveReload("127.0.0.1:0", slog.Default())
+	if err != nil {
+		t.Fatalf("unable to start live reload server: %s", err)
+	}
+	defer lr.Close()
+	go lr.serve()
+	resp, err := http.Get("http://" + lr.ln.Addr().String() + "/livereload")
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("want an event stream, got %#v", ct)
+	}
+	lines := make(chan string, 10)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if strings.HasPrefix(sc.Text(), "event: ") {
+				lines <- sc.Text()
+			}
+		}
+	}()
+	for {
+		lr.mu.Lock()
+		n := len(lr.clients)
+		lr.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lr.settle = 50 * time.Millisecond
+	f := lr.follow(false)
+	// Runs justrun stopped and runs that failed don't cause reloads.
+	f(runChange{state: runStarted, gen: 1, changed: []string{"/a/main.go"}})
+	f(runChange{state: runReady, gen: 1})
+	f(runChange{state: runStopping, gen: 1})
+	f(runChange{state: runExited, gen: 1})
+	f(runChange{state: runStarted, gen: 2, changed: []string{"/a/main.go"}})
+	f(runChange{state: runReady, gen: 2})
+	f(runChange{state: runExited, gen: 2, err: errors.New("exit status 1")})
+	// A run that exits successfully before it settles causes one.
+	f(runChange{state: runStarted, gen: 3, changed: []string{"/a/site.css"}})
+	f(runChange{state: runReady, gen: 3})
+	f(runChange{state: runExited, gen: 3})
+
+	ready := lr.follow(true)
+	ready(runChange{state: runStarted, gen: 1, changed: []string{"/a/main.go"}})
+	ready(runChange{state: runReady, gen: 1})
+
+	// So does a server still running once it settles.
+	time.Sleep(2 * lr.settle)
+	f(runChange{state: runStarted, gen: 4, changed: []string{"/a/index.html"}})
+	f(runChange{state: runReady, gen: 4})
+
+	for _, want := range []string{"event: css", "event: reload", "event: reload"} {
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Errorf("want %#v, got %#v", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("never got %#v", want)
+		}
+	}
+	select {
+	case got := <-lines:
+		t.Errorf("unexpected %#v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", false)
+	if err != nil {
+		t.Fatalf("newLogger: %s", err)
+	}
+	logger.Debug("ignored file change", "path", "/a/b.swp")
+	logger.Info("running command", "command", "make")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("want only the info message without verbose, got %q", buf.String())
+	}
+	var rec map[string]interface{}
+	err = json.Unmarshal([]byte(lines[0]), &rec)
+	if err != nil {
+		t.Fatalf("unable to parse %q: %s", lines[0], err)
+	}
+	if rec["level"] != "INFO" || rec["command"] != "make" {
+		t.Errorf("want an INFO record with the command, got %v", rec)
+	}
+
+	buf.Reset()
+	logger, err = newLogger(&buf, "text", true)
+	if err != nil {
+		t.Fatalf("newLogger: %s", err)
+	}
+	logger.Debug("ignored file change", "path", "/a/b.swp")
+	if !strings.Contains(buf.String(), "level=DEBUG") {
+		t.Errorf("want debug messages with verbose, got %q", buf.String())
+	}
+
+	_, err = newLogger(&buf, "xml", false)
+	if err == nil {
+		t.Errorf("want an error for an unknown format")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// opNames are the names of the fsnotify ops in -ops.
+var opNames = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// opsRule is the fsnotify ops that cause the command to run when they
+// happen to the paths matching its patterns, or to every path if it has
+// none.
+type opsRule struct {
+	ops      fsnotify.Op
+	patterns *patternIgnorer
+}
+
+// parseOps parses comma-separated op names, like "create,write".
+func parseOps(s string) (fsnotify.Op, error) {
+	var ops fsnotify.Op
+	for _, name := range strings.Split(s, ",") {
+		op, ok := opNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown op '%s', should be one of create, write, remove, rename, or chmod", name)
+		}
+		ops |= op
+	}
+	return ops, nil
+}
+
+// parseOpsRule parses op names optionally followed by a colon and a
+// gitignore-style pattern, like "create,remove:*.lock".
+func parseOpsRule(base, s string) (*opsRule, error) {
+	spec, pattern, hasPattern := strings.Cut(s, ":")
+	ops, err := parseOps(spec)
+	if err != nil {
+		return nil, err
+	}
+	or := &opsRule{ops: ops}
+	if hasPattern {
+		or.patterns, err = createPatternIgnorer(base, []string{pattern})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return or, nil
+}
+
+// wantsOp returns true if ev's op causes a run for its path. The first
+// rule with a pattern matching the path decides, then the rule without a
+// pattern, if there is one. Every op is wanted without one.
+func wantsOp(rules []*opsRule, ev fsnotify.Event) bool {
+	var fallback *opsRule
+	for _, or := range rules {
+		if or.patterns == nil {
+			if fallback == nil {
+				fallback = or
+			}
+			continue
+		}
+		if or.patterns.IsIgnored(ev.Name) {
+			return ev.Op&or.ops != 0
+		}
+	}
+	if fallback == nil {
+		return true
+	}
+	return ev.Op&fallback.ops != 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestParseOps(t *testing.T) {
+	ops, err := parseOps("create, Write,remove")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ops != fsnotify.Create|fsnotify.Write|fsnotify.Remove {
+		t.Errorf("want create, write and remove, got %s", ops)
+	}
+	_, err = parseOps("create,close_write")
+	if err == nil {
+		t.Errorf("want an error for an unknown op")
+	}
+}
+
+func TestWantsOp(t *testing.T) {
+	var rules []*opsRule
+	for _, s := range []string{"create,remove:*.lock", "write,create", "chmod:*.sh"} {
+		or, err := parseOpsRule("/base", s)
+		if err != nil {
+			t.Fatalf("%#v: unexpected error: %s", s, err)
+		}
+		rules = append(rules, or)
+	}
+	tests := []struct {
+		path string
+		op   fsnotify.Op
+		want bool
+	}{
+		{"/base/yarn.lock", fsnotify.Create, true},
+		{"/base/yarn.lock", fsnotify.Write, false},
+		{"/base/run.sh", fsnotify.Chmod, true},
+		{"/base/run.sh", fsnotify.Write, false},
+		{"/base/main.go", fsnotify.Write, true},
+		{"/base/main.go", fsnotify.Chmod, false},
+		{"/base/main.go", fsnotify.Rename, false},
+	}
+	for _, tc := range tests {
+		got := wantsOp(rules, fsnotify.Event{Name: tc.path, Op: tc.op})
+		if got != tc.want {
+			t.Errorf("%s of %s: want %t, got %t", tc.op, tc.path, tc.want, got)
+		}
+	}
+	if !wantsOp(nil, fsnotify.Event{Name: "/base/main.go", Op: fsnotify.Chmod}) {
+		t.Errorf("every op should be wanted without any rules")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollBackend finds changes by comparing the state of the watched paths
+// every interval. It works on filesystems that don't tell the OS about
+// changes made elsewhere, like NFS, FUSE, and the folders VMs and
+// containers share with their hosts.
+type pollBackend struct {
+	interval  time.Duration
+	events    chan fsnotify.Event
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// watched maps each watched path to the states of it and, if it is
+	// a directory, the paths inside of it.
+	watched map[string]map[string]fileState
+}
+
+// fileState is what pollBackend compares to find changes to a path.
+type fileState struct {
+	mtime time.Time
+	size  int64
+	ino   uint64
+	mode  fs.FileMode
+}
+
+func newPollBackend(interval time.Duration) *pollBackend {
+	pb := &pollBackend{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		watched:  make(map[string]map[string]fileState),
+	}
+	go pb.loop()
+	return pb
+}
+
+func (pb *pollBackend) Add(path string) error {
+	states, err := scanPath(path)
+	if err != nil {
+		return err
+	}
+	pb.mu.Lock()
+	pb.watched[path] = states
+	pb.mu.Unlock()
+	return nil
+}
+
+func (pb *pollBackend) Remove(path string) error {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	if _, ok := pb.watched[path]; !ok {
+		return errors.New("can't remove non-existent poll watch")
+	}
+	delete(pb.watched, path)
+	return nil
+}
+
+func (pb *pollBackend) Events() <-chan fsnotify.Event { return pb.events }
+func (pb *pollBackend) Errors() <-chan error          { return pb.errors }
+
+func (pb *pollBackend) Close() error {
+	pb.closeOnce.Do(func() { close(pb.done) })
+	return nil
+}
+
+func (pb *pollBackend) loop() {
+	defer close(pb.errors)
+	defer close(pb.events)
+	t := time.NewTicker(pb.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-pb.done:
+			return
+		}
+		if !pb.poll() {
+			return
+		}
+	}
+}
+
+// poll scans the watched paths once and sends the events for the changes
+// to them. It returns false if the backend was closed.
+func (pb *pollBackend) poll() bool {
+	pb.mu.Lock()
+	paths := make([]string, 0, len(pb.watched))
+	for path := range pb.watched {
+		paths = append(paths, path)
+	}
+	pb.mu.Unlock()
+	sort.Strings(paths)
+
+	var evs []fsnotify.Event
+	for _, path := range paths {
+		states, err := scanPath(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			states, err = map[string]fileState{}, nil
+		}
+		if err != nil {
+			select {
+			case pb.errors <- err:
+				continue
+			case <-pb.done:
+				return false
+			}
+		}
+		pb.mu.Lock()
+		old, ok := pb.watched[path]
+		if ok {
+			pb.watched[path] = states
+		}
+		pb.mu.Unlock()
+		// The path may have been removed during the scan.
+		if ok {
+			evs = append(evs, diffStates(old, states)...)
+		}
+	}
+	// The events are sent without pb.mu held because the watcher adds
+	// paths while handling them.
+	for _, ev := range evs {
+		select {
+		case pb.events <- ev:
+		case <-pb.done:
+			return false
+		}
+	}
+	return true
+}
+
+// scanPath returns the states of path and, if it is a directory, the
+// paths inside of it.
+func scanPath(path string) (map[string]fileState, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]fileState{path: newFileState(fi)}
+	if !fi.IsDir() {
+		return states, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			// It was removed after the directory was read.
+			continue
+		}
+		states[filepath.Join(path, e.Name())] = newFileState(info)
+	}
+	return states, nil
+}
+
+func newFileState(fi fs.FileInfo) fileState {
+	st := fileState{mtime: fi.ModTime(), size: fi.Size(), mode: fi.Mode()}
+	if sys, ok := fi.Sys().(*syscall.Stat_t); ok {
+		st.ino = uint64(sys.Ino)
+	}
+	return st
+}
+
+// diffStates returns the events that describe the changes from the old
+// states of the paths to the current ones. A path that disappeared while a
+// new one with the same inode appeared was renamed. Like with inotify,
+// changes to a directory's contents are not writes to the directory.
+func diffStates(old, cur map[string]fileState) []fsnotify.Event {
+	var created, gone, kept []string
+	for path := range cur {
+		if _, ok := old[path]; ok {
+			kept = append(kept, path)
+		} else {
+			created = append(created, path)
+		}
+	}
+	for path := range old {
+		if _, ok := cur[path]; !ok {
+			gone = append(gone, path)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(gone)
+	sort.Strings(kept)
+
+	createdInos := make(map[uint64]bool)
+	for _, path := range created {
+		createdInos[cur[path].ino] = true
+	}
+	var evs []fsnotify.Event
+	for _, path := range gone {
+		op := fsnotify.Remove
+		if ino := old[path].ino; ino != 0 && createdInos[ino] {
+			op = fsnotify.Rename
+		}
+		evs = append(evs, fsnotify.Event{Name: path, Op: op})
+	}
+	for _, path := range created {
+		evs = append(evs, fsnotify.Event{Name: path, Op: fsnotify.Create})
+	}
+	for _, path := range kept {
+		o, c := old[path], cur[path]
+		switch {
+		case o.ino != c.ino:
+			// Something was put in its place, like by an editor
+			// renaming a new file over it.
+			evs = append(evs, fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case c.mode.IsDir():
+		case !o.mtime.Equal(c.mtime) || o.size != c.size:
+			evs = append(evs, fsnotify.Event{Name: path, Op: fsnotify.Write})
+		case o.mode != c.mode:
+			evs = append(evs, fsnotify.Event{Name: path, Op: fsnotify.Chmod})
+		}
+	}
+	return evs
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestDiffStates(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	t1 := time.Unix(2000, 0)
+	old := map[string]fileState{
+		"/d":         {mtime: t0, ino: 1, mode: os.ModeDir | 0755},
+		"/d/same":    {mtime: t0, size: 3, ino: 2, mode: 0644},
+		"/d/written": {mtime: t0, size: 3, ino: 3, mode: 0644},
+		"/d/chmod":   {mtime: t0, size: 3, ino: 4, mode: 0644},
+		"/d/removed": {mtime: t0, size: 3, ino: 5, mode: 0644},
+		"/d/old":     {mtime: t0, size: 3, ino: 6, mode: 0644},
+		"/d/swapped": {mtime: t0, size: 3, ino: 7, mode: 0644},
+		"/d/sub":     {mtime: t0, ino: 8, mode: os.ModeDir | 0755},
+	}
+	cur := map[string]fileState{
+		"/d":         {mtime: t1, ino: 1, mode: os.ModeDir | 0755},
+		"/d/same":    {mtime: t0, size: 3, ino: 2, mode: 0644},
+		"/d/written": {mtime: t1, size: 3, ino: 3, mode: 0644},
+		"/d/chmod":   {mtime: t0, size: 3, ino: 4, mode: 0600},
+		"/d/new":     {mtime: t0, size: 3, ino: 6, mode: 0644},
+		"/d/swapped": {mtime: t0, size: 3, ino: 9, mode: 0644},
+		"/d/sub":     {mtime: t1, ino: 8, mode: os.ModeDir | 0755},
+		"/d/created": {mtime: t1, ino: 10, mode: 0644},
+	}
+	want := []fsnotify.Event{
+		{Name: "/d/old", Op: fsnotify.Rename},
+		{Name: "/d/removed", Op: fsnotify.Remove},
+		{Name: "/d/created", Op: fsnotify.Create},
+		{Name: "/d/new", Op: fsnotify.Create},
+		{Name: "/d/chmod", Op: fsnotify.Chmod},
+		{Name: "/d/swapped", Op: fsnotify.Create},
+		{Name: "/d/written", Op: fsnotify.Write},
+	}
+	got := diffStates(old, cur)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestPollBackend(t *testing.T) {
+	dir := t.TempDir()
+	pb := newPollBackend(10 * time.Millisecond)
+	err := pb.Add(dir)
+	if err != nil {
+		t.Fatalf("unable to add %s: %s", dir, err)
+	}
+	see := func(want ...fsnotify.Event) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case ev := <-pb.Events():
+				if ev != w {
+					t.Errorf("want %v, got %v", w, ev)
+				}
+			case <-time.After(waitForMsg):
+				t.Fatalf("did not see %v", w)
+			}
+		}
+	}
+	foo := filepath.Join(dir, "foo")
+	bar := filepath.Join(dir, "bar")
+	os.WriteFile(foo, []byte("a"), 0666)
+	see(fsnotify.Event{Name: foo, Op: fsnotify.Create})
+	os.WriteFile(foo, []byte("ab"), 0666)
+	see(fsnotify.Event{Name: foo, Op: fsnotify.Write})
+	os.Rename(foo, bar)
+	see(fsnotify.Event{Name: foo, Op: fsnotify.Rename}, fsnotify.Event{Name: bar, Op: fsnotify.Create})
+	os.Remove(bar)
+	see(fsnotify.Event{Name: bar, Op: fsnotify.Remove})
+
+	pb.Close()
+	select {
+	case _, ok := <-pb.Events():
+		if ok {
+			t.Errorf("got an event after closing")
+		}
+	case <-time.After(waitForMsg):
+		t.Errorf("events not closed after closing")
+	}
+}
+
+func TestPollWatch(t *testing.T) {
+	fs := newFS(t)
+	fs.MkdirAll("pollDir")
+	w, err := newWatcher(watcherOptions{poll: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newWatcher: %s", err)
+	}
+	ch := make(chan event, 10)
+	err = w.addSet(watchConfig{inputPaths: []string{fs.Abs("pollDir")}, recursive: true}, ch)
+	if err != nil {
+		t.Fatalf("addSet: %s", err)
+	}
+	w.start()
+	defer func() {
+		w.Close()
+		fs.Close()
+	}()
+	fs.MkdirAll("pollDir/sub")
+	seeCreation(fs, ch, "pollDir/sub")
+	// Wait for the new directory to be polled before writing to it.
+	time.Sleep(50 * time.Millisecond)
+	fs.Create("pollDir/sub/foo.go")
+	seeCreation(fs, ch, "pollDir/sub/foo.go")
+}
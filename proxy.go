@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// proxyHoldTimeout is the longest the proxy holds a request while waiting
+// for the command's server to be ready.
+const proxyHoldTimeout = 30 * time.Second
+
+// proxyRetryInterval is the time between attempts to connect to a server
+// that is ready but not yet accepting connections.
+const proxyRetryInterval = 50 * time.Millisecond
+
+// proxy is a reverse proxy in front of the server the command runs. It
+// holds requests while the server is being restarted and, when a run exits
+// on its own, like after a failed build, serves its output as an error
+// page.
+type proxy struct {
+	upstream string
+	ln       net.Listener
+	rp       *httputil.ReverseProxy
+	log      *slog.Logger
+
+	mu sync.Mutex
+	// up is true if the current run is ready.
+	up bool
+	// stopping is true if justrun is terminating the current run.
+	stopping bool
+	// down, if not nil, is the run that exited on its own since the last
+	// one started.
+	down *runChange
+	// changed is closed and replaced when the fields above change.
+	changed chan struct{}
+}
+
+// listenProxy starts listening on addr for requests to forward to the
+// upstream address.
+func listenProxy(addr, upstream string, log *slog.Logger) (*proxy, error) {
+	up, err := upstreamAddr(upstream)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on '%s': %s", addr, err)
+	}
+	p := &proxy{upstream: up, ln: ln, log: log, changed: make(chan struct{})}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = p.dial
+	p.rp = httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: up})
+	p.rp.Transport = tr
+	p.rp.ErrorHandler = p.proxyError
+	return p, nil
+}
+
+// upstreamAddr returns the address of the upstream, which may leave out the
+// host, like ":8081", to mean localhost.
+func upstreamAddr(upstream string) (string, error) {
+	host, port, err := net.SplitHostPort(upstream)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse upstream address '%s': %s", upstream, err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// serve handles requests until the proxy is closed.
+func (p *proxy) serve() {
+	err := http.Serve(p.ln, p)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		p.log.Warn("proxy stopped", "err", err)
+	}
+}
+
+func (p *proxy) Close() error {
+	return p.ln.Close()
+}
+
+// onChange follows the runs of the command. It is given to
+// cmdReloader.onChange.
+func (p *proxy) onChange(c runChange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch c.state {
+	case runStarted:
+		p.up = false
+		p.stopping = false
+		p.down = nil
+	case runReady:
+		p.up = true
+	case runStopping:
+		p.up = false
+		p.stopping = true
+	case runExited:
+		p.up = false
+		if !p.stopping {
+			p.down = &c
+		}
+	}
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// ServeHTTP forwards req to the upstream once the current run is ready, or
+// serves the error page of the run that exited.
+func (p *proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	timeout := time.NewTimer(proxyHoldTimeout)
+	defer timeout.Stop()
+	for {
+		p.mu.Lock()
+		up, down, changed := p.up, p.down, p.changed
+		p.mu.Unlock()
+		switch {
+		case down != nil:
+			p.serveFailure(rw, down)
+			return
+		case up:
+			p.rp.ServeHTTP(rw, req)
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			http.Error(rw, "justrun: timed out waiting for the command to be ready", http.StatusServiceUnavailable)
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// dial connects to the upstream. While the current run is ready but the
+// upstream refuses connections, as servers do between starting and
+// listening, it keeps trying.
+func (p *proxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	deadline := time.Now().Add(proxyHoldTimeout)
+	for {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err == nil || !errors.Is(err, syscall.ECONNREFUSED) || time.Now().After(deadline) {
+			return conn, err
+		}
+		p.mu.Lock()
+		up := p.up
+		p.mu.Unlock()
+		if !up {
+			return nil, err
+		}
+		select {
+		case <-time.After(proxyRetryInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// proxyError responds to requests the upstream couldn't be reached for.
+func (p *proxy) proxyError(rw http.ResponseWriter, req *http.Request, err error) {
+	p.mu.Lock()
+	down := p.down
+	p.mu.Unlock()
+	if down != nil {
+		p.serveFailure(rw, down)
+		return
+	}
+	p.log.Debug("unable to reach upstream", "upstream", p.upstream, "err", err)
+	http.Error(rw, fmt.Sprintf("justrun: unable to reach %s: %s", p.upstream, err), http.StatusBadGateway)
+}
+
+var failurePage = template.Must(template.New("failure").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>justrun: {{.Status}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #222; color: #eee; padding: 1em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Status}}</h1>
+<pre>{{printf "%s" .Output}}</pre>
+</body>
+</html>
+`))
+
+// serveFailure serves an error page with the output of the run that
+// exited.
+func (p *proxy) serveFailure(rw http.ResponseWriter, c *runChange) {
+	status := "the command exited"
+	if c.err != nil {
+		status = "the command failed: " + c.err.Error()
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusBadGateway)
+	err := failurePage.Execute(rw, struct {
+		Status string
+		Output []byte
+	}{status, c.output})
+	if err != nil {
+		p.log.Debug("unable to write error page", "err", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, "hello from upstream")
+	}))
+	defer upstream.Close()
+	p, err := listenProxy("127.0.0.1:0", strings.TrimPrefix(upstream.URL, "http://"), slog.Default())
+	if err != nil {
+		t.Fatalf("unable to start proxy: %s", err)
+	}
+	defer p.Close()
+	go p.serve()
+	url := "http://" + p.ln.Addr().String() + "/"
+
+	type result struct {
+		code int
+		body string
+	}
+	get := func() chan result {
+		ch := make(chan result, 1)
+		go func() {
+			resp, err := http.Get(url)
+			if err != nil {
+				t.Errorf("unable to get %s: %s", url, err)
+				ch <- result{}
+				return
+			}
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(resp.Body)
+			ch <- result{resp.StatusCode, string(b)}
+		}()
+		return ch
+	}
+	held := func(ch chan result) {
+		t.Helper()
+		select {
+		case res := <-ch:
+			t.Fatalf("request not held, got %+v", res)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	answered := func(ch chan result, code int, body string) {
+		t.Helper()
+		select {
+		case res := <-ch:
+			if res.code != code || !strings.Contains(res.body, body) {
+				t.Errorf("want %d with %#v, got %d with %#v", code, body, res.code, res.body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request never answered")
+		}
+	}
+
+	p.onChange(runChange{state: runStarted, gen: 1})
+	ch := get()
+	held(ch)
+	p.onChange(runChange{state: runReady, gen: 1})
+	answered(ch, http.StatusOK, "hello from upstream")
+
+	// A restart holds requests until the new run is ready.
+	p.onChange(runChange{state: runStopping, gen: 1})
+	p.onChange(runChange{state: runExited, gen: 1, err: errors.New("signal: terminated")})
+	ch = get()
+	held(ch)
+	p.onChange(runChange{state: runStarted, gen: 2})
+	held(ch)
+	p.onChange(runChange{state: runReady, gen: 2})
+	answered(ch, http.StatusOK, "hello from upstream")
+
+	// A failed build's output is served to held requests.
+	p.onChange(runChange{state: runStopping, gen: 2})
+	p.onChange(runChange{state: runExited, gen: 2})
+	p.onChange(runChange{state: runStarted, gen: 3})
+	ch = get()
+	held(ch)
+	p.onChange(runChange{state: runExited, gen: 3, err: errors.New("exit status 2"), output: []byte("./main.go:3:2: undefined: <x>\n")})
+	answered(ch, http.StatusBadGateway, "./main.go:3:2: undefined: &lt;x&gt;")
+	answered(get(), http.StatusBadGateway, "the command failed: exit status 2")
+}
+
+func TestUpstreamAddr(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{":8081", "localhost:8081"},
+		{"127.0.0.1:8081", "127.0.0.1:8081"},
+		{"[::1]:8081", "[::1]:8081"},
+	}
+	for _, tc := range tests {
+		got, err := upstreamAddr(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("upstreamAddr(%#v): want %#v, got %#v, %v", tc.in, tc.want, got, err)
+		}
+	}
+	_, err := upstreamAddr("8081")
+	if err == nil {
+		t.Errorf("want an error for an address without a port")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "sync"
+
+// eventQueue passes events on to a rule's channel from a goroutine of its
+// own, so that a rule that isn't taking events, like one waiting for a
+// long run of its command, doesn't hold up the watcher and the other
+// rules.
+type eventQueue struct {
+	out chan<- event
+	// wake has a value in it when there are pending events, or is
+	// closed once the queue is.
+	wake chan struct{}
+
+	mu      sync.Mutex
+	pending []event
+	closed  bool
+}
+
+func newEventQueue(out chan<- event) *eventQueue {
+	q := &eventQueue{out: out, wake: make(chan struct{}, 1)}
+	go q.forward()
+	return q
+}
+
+// send queues ev to be sent to the channel without waiting for it to be.
+// Events sent after the queue is closed are dropped.
+func (q *eventQueue) send(ev event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.pending = append(q.pending, ev)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close closes the channel once the pending events have been sent to it.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.wake)
+	}
+}
+
+func (q *eventQueue) forward() {
+	defer close(q.out)
+	for range q.wake {
+		q.flush()
+	}
+	q.flush()
+}
+
+// flush sends the pending events to the channel.
+func (q *eventQueue) flush() {
+	q.mu.Lock()
+	evs := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+	for _, ev := range evs {
+		q.out <- ev
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readyInterval is the time between probes of a run that isn't ready yet.
+const readyInterval = 100 * time.Millisecond
+
+// maxReadyLine is the longest line of output a readyWriter will keep
+// looking for its pattern in.
+const maxReadyLine = 64 * 1024
+
+// readyCheck tells when a run of the command is ready, like when the server
+// it started is accepting connections. Only one of its fields is set.
+type readyCheck struct {
+	// tcpAddr is an address that accepts TCP connections once the run
+	// is ready.
+	tcpAddr string
+	// url is an HTTP URL that returns a 2xx status once the run is
+	// ready.
+	url string
+	// pattern matches a line of the command's stdout once the run is
+	// ready.
+	pattern *regexp.Regexp
+}
+
+// parseReadyCheck parses a readiness check like "tcp:localhost:8080",
+// "http://localhost:8080/health", or "stdout:REGEX".
+func parseReadyCheck(spec string) (*readyCheck, error) {
+	switch {
+	case strings.HasPrefix(spec, "tcp:"):
+		addr := strings.TrimPrefix(spec, "tcp:")
+		_, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse ready check address '%s': %s", addr, err)
+		}
+		return &readyCheck{tcpAddr: addr}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &readyCheck{url: spec}, nil
+	case strings.HasPrefix(spec, "stdout:"):
+		re, err := regexp.Compile(strings.TrimPrefix(spec, "stdout:"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse ready check pattern: %s", err)
+		}
+		return &readyCheck{pattern: re}, nil
+	}
+	return nil, fmt.Errorf("ready check '%s' should be 'tcp:HOST:PORT', an http:// or https:// URL, or 'stdout:REGEX'", spec)
+}
+
+// probe returns true if the address or URL of the check is ready. It
+// always returns false for stdout checks, which are done by readyWriter.
+func (rc *readyCheck) probe() bool {
+	switch {
+	case rc.tcpAddr != "":
+		conn, err := net.DialTimeout("tcp", rc.tcpAddr, time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	case rc.url != "":
+		client := &http.Client{Timeout: time.Second}
+		resp, err := client.Get(rc.url)
+		if err != nil {
+			return false
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode >= 200 && resp.StatusCode < 300
+	}
+	return false
+}
+
+// readyWriter passes the command's output on to w and calls ready the first
+// time a line of it matches pattern.
+type readyWriter struct {
+	w       io.Writer
+	pattern *regexp.Regexp
+	ready   func()
+
+	mu      sync.Mutex
+	matched bool
+	// line is the part of the current line written so far.
+	line []byte
+}
+
+func (rw *readyWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	if !rw.matched {
+		rw.line = append(rw.line, p...)
+		for {
+			i := bytes.IndexByte(rw.line, '\n')
+			if i == -1 {
+				break
+			}
+			if rw.pattern.Match(rw.line[:i]) {
+				rw.matched = true
+				rw.line = nil
+				// ready is called on its own goroutine so that it
+				// may take locks held while the output is written.
+				go rw.ready()
+				break
+			}
+			rw.line = rw.line[i+1:]
+		}
+		if len(rw.line) > maxReadyLine {
+			rw.line = nil
+		}
+	}
+	rw.mu.Unlock()
+	return rw.w.Write(p)
+}
+
+// runState is a stage of a run of the command. The funcs given to
+// cmdReloader.onChange are told about each one.
+type runState int
+
+const (
+	// runStarted is when the run started.
+	runStarted runState = iota
+	// runReady is when the run passed its readiness check, or when it
+	// started if there is none.
+	runReady
+	// runStopping is when justrun began terminating the run.
+	runStopping
+	// runExited is when the run exited.
+	runExited
+)
+
+func (s runState) String() string {
+	switch s {
+	case runStarted:
+		return "started"
+	case runReady:
+		return "ready"
+	case runStopping:
+		return "stopping"
+	case runExited:
+		return "exited"
+	}
+	return fmt.Sprintf("runState(%d)", int(s))
+}
+
+// runChange is a run of the command reaching a new runState.
+type runChange struct {
+	state runState
+	gen   int
+	// changed is the paths that changed since the last run, for
+	// runStarted.
+	changed []string
+	// err is the error the run exited with, and output the end of what
+	// it wrote to stdout and stderr, for runExited.
+	err    error
+	output []byte
+}
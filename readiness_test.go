@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseReadyCheck(t *testing.T) {
+	tests := []struct {
+		spec string
+		want readyCheck
+		ok   bool
+	}{
+		{"tcp:localhost:8080", readyCheck{tcpAddr: "localhost:8080"}, true},
+		{"http://localhost:8080/health", readyCheck{url: "http://localhost:8080/health"}, true},
+		{"https://example.com/", readyCheck{url: "https://example.com/"}, true},
+		{"tcp:8080", readyCheck{}, false},
+		{"stdout:(", readyCheck{}, false},
+		{"localhost:8080", readyCheck{}, false},
+	}
+	for _, tc := range tests {
+		rc, err := parseReadyCheck(tc.spec)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%#v: want an error, got none", tc.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%#v: unexpected error: %s", tc.spec, err)
+			continue
+		}
+		if *rc != tc.want {
+			t.Errorf("%#v: want %+v, got %+v", tc.spec, tc.want, *rc)
+		}
+	}
+	rc, err := parseReadyCheck("stdout:listening on :\\d+")
+	if err != nil || rc.pattern == nil || !rc.pattern.MatchString("listening on :8080") {
+		t.Errorf("stdout check not parsed correctly: %+v, %v", rc, err)
+	}
+}
+
+func TestReadyProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	rc := &readyCheck{tcpAddr: addr}
+	if rc.probe() {
+		t.Errorf("tcp probe of a closed port passed")
+	}
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("unable to listen again: %s", err)
+	}
+	defer ln.Close()
+	if !rc.probe() {
+		t.Errorf("tcp probe of an open port failed")
+	}
+
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(status)
+	}))
+	defer srv.Close()
+	rc = &readyCheck{url: srv.URL}
+	if rc.probe() {
+		t.Errorf("http probe passed with a 503")
+	}
+	status = http.StatusNoContent
+	if !rc.probe() {
+		t.Errorf("http probe failed with a 204")
+	}
+}
+
+func TestReadyWriter(t *testing.T) {
+	var out bytes.Buffer
+	ready := make(chan struct{}, 2)
+	rw := &readyWriter{
+		w:       &out,
+		pattern: regexp.MustCompile(`^listening`),
+		ready:   func() { ready <- struct{}{} },
+	}
+	rw.Write([]byte("starting\nlist"))
+	rw.Write([]byte("ening on :8080"))
+	select {
+	case <-ready:
+		t.Fatalf("ready called before the line was finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	rw.Write([]byte("\nlistening again\n"))
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatalf("ready not called after a matching line")
+	}
+	select {
+	case <-ready:
+		t.Errorf("ready called more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+	want := "starting\nlistening on :8080\nlistening again\n"
+	if out.String() != want {
+		t.Errorf("want output %#v, got %#v", want, out.String())
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// restartPolicy says when a command that exited on its own should be
+// restarted without waiting for a file to change.
+type restartPolicy int
+
+const (
+	restartNever restartPolicy = iota
+	restartOnFailure
+	restartAlways
+)
+
+var restartPolicyNames = map[string]restartPolicy{
+	"never":      restartNever,
+	"on-failure": restartOnFailure,
+	"always":     restartAlways,
+}
+
+// restarts returns true if a command that exited with err, and was not
+// stopped by justrun, should be restarted.
+func (rp restartPolicy) restarts(err error) bool {
+	switch rp {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+func (rp *restartPolicy) String() string {
+	for name, p := range restartPolicyNames {
+		if p == *rp {
+			return name
+		}
+	}
+	return "never"
+}
+
+func (rp *restartPolicy) Set(s string) error {
+	p, ok := restartPolicyNames[s]
+	if !ok {
+		return fmt.Errorf("unknown restart policy '%s', want 'never', 'on-failure', or 'always'", s)
+	}
+	*rp = p
+	return nil
+}
+
+func (rp *restartPolicy) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return errors.New("restart policies must be strings like \"on-failure\"")
+	}
+	return rp.Set(s)
+}
+
+const (
+	// restartMinBackoff is the time to wait before the first restart of a
+	// command after a file changed.
+	restartMinBackoff = 250 * time.Millisecond
+	// restartMaxBackoff is the longest time to wait between restarts.
+	restartMaxBackoff = 30 * time.Second
+)
+
+// backoff computes the exponentially growing times to wait between the
+// restarts of a command that keeps exiting.
+type backoff struct {
+	min, max time.Duration
+	// attempts is the number of restarts since the last reset.
+	attempts int
+}
+
+// next returns the time to wait before the next restart. It is doubled on
+// each call up to max, and then a random part of its second half is taken
+// off so that commands crashing together don't restart together.
+func (b *backoff) next() time.Duration {
+	d := b.min
+	for i := 0; i < b.attempts && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	b.attempts++
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// reset makes the next restart wait the minimum time again.
+func (b *backoff) reset() {
+	b.attempts = 0
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// rule is a command and the watched paths whose changes cause it to be
+// rerun.
+type rule struct {
+	name  string
+	wc    watchConfig
+	sched *scheduler
+	cmd   *cmdReloader
+	cmdCh chan event
+	// ctlCh carries the funcs that control the rule to its run
+	// goroutine.
+	ctlCh chan func()
+
+	// signalOn are the paths whose changes cause a signal to be sent to
+	// the running command instead of it being rerun.
+	signalOn []*signalRule
+
+	// proxyAddr, if not empty, is the address to serve a proxy to the
+	// command's server, at upstream, on.
+	proxyAddr string
+	upstream  string
+
+	// feed, if not nil, is sent the events for the rule's paths as they
+	// arrive.
+	feed *eventFeed
+
+	// mu guards the fields below and wc once the rule is running.
+	mu     sync.Mutex
+	paused bool
+	// lastEvents are the events that caused the last run.
+	lastEvents []event
+}
+
+// signalRule is a signal to send to a running command when only paths
+// matching its patterns have changed.
+type signalRule struct {
+	sig      syscall.Signal
+	patterns *patternIgnorer
+}
+
+// newRule creates the rule described by rc that watches the paths in wc,
+// logs to log, and writes records of its runs to lifecycle. The settings
+// rc leaves out are taken from the flags. Its name may be empty if it is
+// the only rule.
+func newRule(rc *ruleConfig, wc watchConfig, log *slog.Logger, lifecycle *lifecycleLog) (*rule, error) {
+	sh := *shell
+	if rc.Shell != "" {
+		sh = rc.Shell
+	}
+	delay := *delayDur
+	if rc.Delay.Duration != 0 {
+		delay = rc.Delay.Duration
+	}
+	debounce := *debounceDur
+	if rc.Debounce.Duration != 0 {
+		debounce = rc.Debounce.Duration
+	}
+	maxWait := *maxWaitDur
+	if rc.MaxWait.Duration != 0 {
+		maxWait = rc.MaxWait.Duration
+	}
+	wait := *waitForCommand
+	if rc.Wait != nil {
+		wait = *rc.Wait
+	}
+	subst := *substFlag
+	if rc.Subst != nil {
+		subst = *rc.Subst
+	}
+	killAfter := *killAfterDur
+	if rc.KillAfter != nil {
+		killAfter = rc.KillAfter.Duration
+	}
+	restart := restartFlag
+	if rc.Restart != nil {
+		restart = *rc.Restart
+	}
+	readySpec := *readyFlag
+	if rc.Ready != "" {
+		readySpec = rc.Ready
+	}
+	var ready *readyCheck
+	if readySpec != "" {
+		var err error
+		ready, err = parseReadyCheck(readySpec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	proxyAddr, upstream := *proxyFlag, *upstreamFlag
+	if rc.Proxy != "" || rc.Upstream != "" {
+		proxyAddr, upstream = rc.Proxy, rc.Upstream
+	}
+	if (proxyAddr == "") != (upstream == "") {
+		return nil, errors.New("a proxy and an upstream must be given together")
+	}
+	stop := stopSignal.Signal
+	if rc.Signal.Signal != 0 {
+		stop = rc.Signal.Signal
+	}
+	changedFile := *changedFileFlag
+	if rc.ChangedFile != "" {
+		changedFile = rc.ChangedFile
+	}
+	signalOn := rc.SignalOn
+	if len(signalOn) == 0 {
+		signalOn = signalOnFlag
+	}
+	base := wc.patternBase
+	if base == "" {
+		var err error
+		base, err = os.Getwd()
+		if err != nil {
+			return nil, errors.New("unable to get current working dir while working with signal patterns")
+		}
+	}
+	var srs []*signalRule
+	for _, so := range signalOn {
+		sr, err := parseSignalRule(base, so)
+		if err != nil {
+			return nil, err
+		}
+		srs = append(srs, sr)
+	}
+	wc.name = rc.Name
+	wc.changedFile = changedFile
+	if rc.Name != "" {
+		log = log.With("rule", rc.Name)
+	}
+	r := &rule{
+		name:  rc.Name,
+		wc:    wc,
+		sched: newScheduler(debounce, delay, maxWait, realClock{}),
+		cmd: &cmdReloader{
+			name:           rc.Name,
+			log:            log,
+			lifecycle:      lifecycle,
+			cond:           &sync.Cond{L: new(sync.Mutex)},
+			command:        rc.Command,
+			shell:          sh,
+			waitForCommand: wait,
+			killAfter:      killAfter,
+			stopSignal:     stop,
+			changedFile:    changedFile,
+			substitute:     subst,
+			restart:        restart,
+			color:          isTerminal(os.Stdout),
+			summary:        *summaryFlag,
+			ready:          ready,
+			backoff:        backoff{min: restartMinBackoff, max: restartMaxBackoff},
+		},
+		cmdCh:     make(chan event, 100),
+		ctlCh:     make(chan func(), 10),
+		signalOn:  srs,
+		proxyAddr: proxyAddr,
+		upstream:  upstream,
+	}
+	r.sched.observe = r.observe
+	return r, nil
+}
+
+// parseSignalRule parses a signal and a gitignore-style pattern separated by
+// a colon, like "HUP:*.conf".
+func parseSignalRule(base, s string) (*signalRule, error) {
+	i := strings.Index(s, ":")
+	if i == -1 {
+		return nil, fmt.Errorf("'%s' should be a signal and a path pattern separated by a colon, like 'HUP:*.conf'", s)
+	}
+	sig, err := parseSignal(s[:i])
+	if err != nil {
+		return nil, err
+	}
+	pi, err := createPatternIgnorer(base, []string{s[i+1:]})
+	if err != nil {
+		return nil, err
+	}
+	return &signalRule{sig: sig, patterns: pi}, nil
+}
+
+// run runs the rule's command and then reruns it as events for the rule's
+// paths arrive. It returns when the rule's event channel is closed.
+func (r *rule) run() {
+	r.sched.run(r.cmdCh, r.ctlCh, r.trigger)
+}
+
+// rerun reruns the command with the paths changed so far, even if none
+// have, as soon as the rule's run goroutine is free to.
+func (r *rule) rerun() {
+	r.control(func() {
+		evs := r.sched.take()
+		r.setLastEvents(evs)
+		r.cmd.Reload(changedPaths(evs))
+	})
+}
+
+// stop terminates the running command without running it again until more
+// files change. It doesn't go through the run goroutine, which is busy
+// waiting for the command with -w.
+func (r *rule) stop() {
+	r.cmd.Stop()
+}
+
+// setPaused stops or resumes rerunning the command as files change. The
+// changes made while paused cause a run once it is resumed.
+func (r *rule) setPaused(paused bool) {
+	r.mu.Lock()
+	r.paused = paused
+	r.mu.Unlock()
+	r.control(func() {})
+}
+
+// control has the rule's run goroutine call f and then bring the scheduler
+// up to date with whether the rule is paused. So that callers are never
+// held up, like while the run goroutine waits for a run with -w, f is
+// dropped if too many funcs are already waiting. Those still update the
+// pause.
+func (r *rule) control(f func()) {
+	select {
+	case r.ctlCh <- func() {
+		f()
+		r.mu.Lock()
+		r.sched.paused = r.paused
+		r.mu.Unlock()
+	}:
+	default:
+		r.cmd.log.Warn("dropped a request to control the command while it is busy")
+	}
+}
+
+// editPaths changes the paths the rule watches with w to the ones edit
+// returns when given the current ones.
+func (r *rule) editPaths(w *watcher, edit func(paths []string) []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wc := r.wc
+	wc.inputPaths = edit(append([]string(nil), r.wc.inputPaths...))
+	err := w.updateSet(wc, r.cmdCh)
+	if err != nil {
+		return err
+	}
+	r.wc = wc
+	return nil
+}
+
+func (r *rule) observe(ev event) {
+	if r.feed != nil {
+		r.feed.publish(r.name, ev)
+	}
+}
+
+func (r *rule) setLastEvents(evs []event) {
+	r.mu.Lock()
+	r.lastEvents = evs
+	r.mu.Unlock()
+}
+
+// trigger reruns the command in response to the given events. If all of
+// the events are for paths that only require a signal to be sent to the
+// command and the command is running, the signal is sent instead.
+func (r *rule) trigger(evs []event) {
+	r.setLastEvents(evs)
+	if len(evs) == 0 {
+		r.cmd.Reload(nil)
+		return
+	}
+	sig := r.signalFor(evs)
+	if sig != 0 && r.cmd.Signal(sig) {
+		r.cmd.log.Info("sent signal to current command instead of rerunning it", "signal", sig)
+		return
+	}
+	r.cmd.Reload(changedPaths(evs))
+}
+
+// changedPaths returns the sorted, unique paths of the events.
+func changedPaths(evs []event) []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, ev := range evs {
+		if !ev.lost() && !seen[ev.Event.Name] {
+			seen[ev.Event.Name] = true
+			paths = append(paths, ev.Event.Name)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// signalFor returns the signal that all of the events' paths call for, or 0
+// if they don't all call for the same one.
+func (r *rule) signalFor(evs []event) syscall.Signal {
+	var sig syscall.Signal
+	for _, ev := range evs {
+		var evSig syscall.Signal
+		for _, sr := range r.signalOn {
+			if sr.patterns.IsIgnored(ev.Event.Name) {
+				evSig = sr.sig
+				break
+			}
+		}
+		if evSig == 0 || (sig != 0 && evSig != sig) {
+			return 0
+		}
+		sig = evSig
+	}
+	return sig
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log/slog"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestSignalFor(t *testing.T) {
+	r, err := newRule(&ruleConfig{
+		Command:  "true",
+		SignalOn: []string{"HUP:*.conf", "USR2:static/**"},
+	}, watchConfig{patternBase: "/base"}, slog.Default(), nil)
+	if err != nil {
+		t.Fatalf("unable to create rule: %s", err)
+	}
+	evs := func(paths ...string) []event {
+		var evs []event
+		for _, p := range paths {
+			evs = append(evs, event{Event: fsnotify.Event{Name: p, Op: fsnotify.Write}})
+		}
+		return evs
+	}
+	tests := []struct {
+		paths []string
+		want  syscall.Signal
+	}{
+		{[]string{"/base/nginx.conf"}, syscall.SIGHUP},
+		{[]string{"/base/nginx.conf", "/base/sub/site.conf"}, syscall.SIGHUP},
+		{[]string{"/base/static/app.css"}, syscall.SIGUSR2},
+		{[]string{"/base/nginx.conf", "/base/main.go"}, 0},
+		{[]string{"/base/nginx.conf", "/base/static/app.css"}, 0},
+		{[]string{"/base/main.go"}, 0},
+	}
+	for _, tc := range tests {
+		got := r.signalFor(evs(tc.paths...))
+		if got != tc.want {
+			t.Errorf("signalFor(%v) = %v, want %v", tc.paths, got, tc.want)
+		}
+	}
+}
+
+func TestRuleWait(t *testing.T) {
+	keepFlag(t, waitForCommand)
+	yes, no := true, false
+	tests := []struct {
+		flag bool
+		rule *bool
+		want bool
+	}{
+		{false, nil, false},
+		{true, nil, true},
+		{true, &no, false},
+		{false, &yes, true},
+	}
+	for _, tc := range tests {
+		*waitForCommand = tc.flag
+		r, err := newRule(&ruleConfig{Command: "true", Wait: tc.rule}, watchConfig{patternBase: "/base"}, slog.Default(), nil)
+		if err != nil {
+			t.Fatalf("unable to create rule: %s", err)
+		}
+		if r.cmd.waitForCommand != tc.want {
+			t.Errorf("-w=%t, rule wait %v: want %t, got %t", tc.flag, tc.rule != nil && *tc.rule, tc.want, r.cmd.waitForCommand)
+		}
+	}
+}
+
+func TestRuleSubst(t *testing.T) {
+	keepFlag(t, substFlag)
+	yes, no := true, false
+	tests := []struct {
+		flag bool
+		rule *bool
+		want bool
+	}{
+		{false, nil, false},
+		{true, nil, true},
+		{true, &no, false},
+		{false, &yes, true},
+	}
+	for i, tc := range tests {
+		*substFlag = tc.flag
+		r, err := newRule(&ruleConfig{Command: "true", Subst: tc.rule}, watchConfig{patternBase: "/base"}, slog.Default(), nil)
+		if err != nil {
+			t.Fatalf("unable to create rule: %s", err)
+		}
+		if r.cmd.substitute != tc.want {
+			t.Errorf("%d: want %t, got %t", i, tc.want, r.cmd.substitute)
+		}
+	}
+}
+
+func TestRuleRestart(t *testing.T) {
+	keepFlag(t, &restartFlag)
+	never, always := restartNever, restartAlways
+	tests := []struct {
+		flag restartPolicy
+		rule *restartPolicy
+		want restartPolicy
+	}{
+		{restartNever, nil, restartNever},
+		{restartAlways, nil, restartAlways},
+		{restartAlways, &never, restartNever},
+		{restartOnFailure, &always, restartAlways},
+	}
+	for i, tc := range tests {
+		restartFlag = tc.flag
+		r, err := newRule(&ruleConfig{Command: "true", Restart: tc.rule}, watchConfig{patternBase: "/base"}, slog.Default(), nil)
+		if err != nil {
+			t.Fatalf("unable to create rule: %s", err)
+		}
+		if r.cmd.restart != tc.want {
+			t.Errorf("%d: want %s, got %s", i, &tc.want, &r.cmd.restart)
+		}
+	}
+}
+
+func TestRuleKillAfter(t *testing.T) {
+	keepFlag(t, killAfterDur)
+	tests := []struct {
+		flag time.Duration
+		rule *duration
+		want time.Duration
+	}{
+		{0, nil, 0},
+		{5 * time.Second, nil, 5 * time.Second},
+		{5 * time.Second, &duration{0}, 0},
+		{0, &duration{time.Second}, time.Second},
+	}
+	for i, tc := range tests {
+		*killAfterDur = tc.flag
+		r, err := newRule(&ruleConfig{Command: "true", KillAfter: tc.rule}, watchConfig{patternBase: "/base"}, slog.Default(), nil)
+		if err != nil {
+			t.Fatalf("unable to create rule: %s", err)
+		}
+		if r.cmd.killAfter != tc.want {
+			t.Errorf("%d: want %s, got %s", i, tc.want, r.cmd.killAfter)
+		}
+	}
+}
+
+func TestStopWaitingRule(t *testing.T) {
+	yes := true
+	r, err := newRule(&ruleConfig{Command: "sleep 10", Wait: &yes}, watchConfig{patternBase: "/base"}, slog.Default(), nil)
+	if err != nil {
+		t.Fatalf("unable to create rule: %s", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		r.run()
+		close(done)
+	}()
+	defer func() {
+		close(r.cmdCh)
+		<-done
+	}()
+	for !r.cmd.Status().Running {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		r.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		r.cmd.Terminate()
+		t.Fatalf("stop didn't stop a run being waited for")
+	}
+	if r.cmd.Status().Running {
+		t.Errorf("the command is still running after stop")
+	}
+	// The run goroutine is free again to handle more.
+	for i := 0; i < 20; i++ {
+		r.setPaused(i%2 == 0)
+	}
+}
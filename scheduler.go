@@ -0,0 +1,119 @@
+package main
+
+import "time"
+
+// clock is the source of time for a scheduler. Tests replace it to control
+// time.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// scheduler decides when a command should be rerun in response to the
+// events sent to it. A run happens once no events have arrived for the
+// quiet period, but no sooner than interval after the previous run. A
+// steady stream of events that never leaves a quiet period still causes a
+// run maxWait after the first of them.
+type scheduler struct {
+	// quiet is how long to wait after the last event before running.
+	quiet time.Duration
+	// interval is the minimum time between the starts of two runs.
+	interval time.Duration
+	// maxWait is the longest a run is put off by a stream of events.
+	// Zero means there is no limit.
+	maxWait time.Duration
+	clock   clock
+
+	pending []event
+	// first and last are the times of the first and last pending
+	// events.
+	first, last time.Time
+	lastRun     time.Time
+	// paused causes events to be held without running them until it is
+	// unset.
+	paused bool
+	// observe, if not nil, is called with every event as it arrives.
+	observe func(event)
+}
+
+func newScheduler(quiet, interval, maxWait time.Duration, c clock) *scheduler {
+	return &scheduler{
+		quiet:    quiet,
+		interval: interval,
+		maxWait:  maxWait,
+		clock:    c,
+	}
+}
+
+// add records an event. Events that occurred before the last run started
+// are dropped because that run already saw their changes.
+func (s *scheduler) add(ev event) {
+	if s.lastRun.After(ev.Time) {
+		return
+	}
+	if len(s.pending) == 0 {
+		s.first = ev.Time
+	}
+	s.last = ev.Time
+	s.pending = append(s.pending, ev)
+}
+
+// due returns the time the pending events should be run at. It returns
+// false if there are no pending events.
+func (s *scheduler) due() (time.Time, bool) {
+	if len(s.pending) == 0 {
+		return time.Time{}, false
+	}
+	t := s.last.Add(s.quiet)
+	if s.maxWait > 0 {
+		if limit := s.first.Add(s.maxWait); limit.Before(t) {
+			t = limit
+		}
+	}
+	if next := s.lastRun.Add(s.interval); next.After(t) {
+		t = next
+	}
+	return t, true
+}
+
+// take returns the pending events and records that a run of them started
+// now.
+func (s *scheduler) take() []event {
+	evs := s.pending
+	s.pending = nil
+	s.lastRun = s.clock.Now()
+	return evs
+}
+
+// run calls trigger once right away, and then again with the pending
+// events whenever they are due. The funcs sent on ctlCh are called between
+// the calls to trigger so that they may use the scheduler and whatever
+// trigger uses without racing with it. It returns when evCh is closed.
+func (s *scheduler) run(evCh <-chan event, ctlCh <-chan func(), trigger func([]event)) {
+	trigger(s.take())
+	for {
+		var timer <-chan time.Time
+		if t, ok := s.due(); ok && !s.paused {
+			timer = s.clock.After(t.Sub(s.clock.Now()))
+		}
+		select {
+		case ev, ok := <-evCh:
+			if !ok {
+				return
+			}
+			if s.observe != nil {
+				s.observe(ev)
+			}
+			s.add(ev)
+		case f := <-ctlCh:
+			f()
+		case <-timer:
+			trigger(s.take())
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeClock is a clock whose time only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	c := &fakeClock{now: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{c.now.Add(d), ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance moves the clock forward, firing the timers that come due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var left []fakeTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			left = append(left, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = left
+}
+
+// waitForTimer blocks until a timer has been created with After.
+func (c *fakeClock) waitForTimer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) == 0 {
+		c.cond.Wait()
+	}
+}
+
+func testEvent(t time.Time, name string) event {
+	return event{Time: t, Event: fsnotify.Event{Name: name, Op: fsnotify.Write}}
+}
+
+func TestSchedulerDue(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+	s := newScheduler(100*time.Millisecond, time.Second, 5*time.Second, c)
+	s.take()
+
+	if _, ok := s.due(); ok {
+		t.Fatalf("due with no pending events")
+	}
+
+	// Throttled: the quiet period ends before the interval does.
+	s.add(testEvent(start.Add(10*time.Millisecond), "a"))
+	if due, _ := s.due(); !due.Equal(start.Add(time.Second)) {
+		t.Errorf("throttled: want due at %s, got %s", start.Add(time.Second), due)
+	}
+
+	// Debounced: each event pushes the run back by the quiet period.
+	s.add(testEvent(start.Add(2*time.Second), "b"))
+	if due, _ := s.due(); !due.Equal(start.Add(2100 * time.Millisecond)) {
+		t.Errorf("debounced: want due at %s, got %s", start.Add(2100*time.Millisecond), due)
+	}
+
+	// Capped: a steady stream of events can't put it off past maxWait.
+	s.add(testEvent(start.Add(5*time.Second+50*time.Millisecond), "c"))
+	if due, _ := s.due(); !due.Equal(start.Add(5*time.Second + 10*time.Millisecond)) {
+		t.Errorf("capped: want due at %s, got %s", start.Add(5*time.Second+10*time.Millisecond), due)
+	}
+
+	// Events from before the last run started are dropped.
+	c.Advance(6 * time.Second)
+	evs := s.take()
+	if len(evs) != 3 {
+		t.Errorf("want 3 pending events, got %d", len(evs))
+	}
+	s.add(testEvent(start.Add(5*time.Second), "d"))
+	if _, ok := s.due(); ok {
+		t.Errorf("stale event was not dropped")
+	}
+}
+
+func TestSchedulerRunCoalescesBursts(t *testing.T) {
+	c := newFakeClock()
+	s := newScheduler(100*time.Millisecond, 750*time.Millisecond, 5*time.Second, c)
+	evCh := make(chan event)
+	runs := make(chan []event, 10)
+	go s.run(evCh, nil, func(evs []event) { runs <- evs })
+
+	if evs := <-runs; len(evs) != 0 {
+		t.Fatalf("first run should have no events, got %d", len(evs))
+	}
+	c.Advance(time.Second)
+	// A burst of 40 saves from gofmt, 5ms apart.
+	for i := 0; i < 40; i++ {
+		evCh <- testEvent(c.Now(), "file")
+		c.Advance(5 * time.Millisecond)
+	}
+	c.waitForTimer()
+	c.Advance(100 * time.Millisecond)
+	select {
+	case evs := <-runs:
+		if len(evs) != 40 {
+			t.Errorf("want one run with all 40 events, got %d", len(evs))
+		}
+	case <-time.After(waitForMsg):
+		t.Fatalf("burst of events did not cause a run")
+	}
+	close(evCh)
+	select {
+	case evs := <-runs:
+		t.Errorf("want a single run for the burst, got another with %d events", len(evs))
+	default:
+	}
+}
+
+func TestSchedulerPause(t *testing.T) {
+	c := newFakeClock()
+	s := newScheduler(100*time.Millisecond, 0, 0, c)
+	evCh := make(chan event)
+	ctlCh := make(chan func())
+	runs := make(chan []event, 10)
+	go s.run(evCh, ctlCh, func(evs []event) { runs <- evs })
+	<-runs
+
+	ctlCh <- func() { s.paused = true }
+	c.Advance(time.Second)
+	evCh <- testEvent(c.Now(), "file")
+	c.Advance(time.Minute)
+	select {
+	case <-runs:
+		t.Fatalf("ran while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ctlCh <- func() { s.paused = false }
+	c.waitForTimer()
+	c.Advance(0)
+	select {
+	case evs := <-runs:
+		if len(evs) != 1 {
+			t.Errorf("want the event from while paused, got %d events", len(evs))
+		}
+	case <-time.After(waitForMsg):
+		t.Fatalf("resuming did not cause a run")
+	}
+	close(evCh)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// signalNames are the signals that can be given by name in flags and config
+// files.
+var signalNames = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal parses a signal given by name, with or without its "SIG"
+// prefix, or by number.
+func parseSignal(s string) (syscall.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "SIG")
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("unknown signal '%s'", s)
+	}
+	return syscall.Signal(n), nil
+}
+
+// signalValue is a signal that is given by its name in flags and config
+// files.
+type signalValue struct {
+	syscall.Signal
+}
+
+func (sv *signalValue) String() string {
+	for name, sig := range signalNames {
+		if sig == sv.Signal {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sv.Signal))
+}
+
+func (sv *signalValue) Set(s string) error {
+	sig, err := parseSignal(s)
+	if err != nil {
+		return err
+	}
+	sv.Signal = sig
+	return nil
+}
+
+func (sv *signalValue) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("signals must be strings like \"TERM\"")
+	}
+	return sv.Set(s)
+}
@@ -1,71 +1,423 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
-// watch watches the input paths. The returned Watcher should only be used in
+// watchConfig describes the paths a watcher sends events for and the paths
+// it ignores.
+type watchConfig struct {
+	inputPaths   []string
+	ignoredPaths []string
+
+	// ignorePatterns are gitignore-style patterns of paths to ignore,
+	// relative to patternBase, or the current working directory if
+	// patternBase is empty.
+	ignorePatterns []string
+	patternBase    string
+
+	// gitignore causes the paths ignored by the .gitignore files of
+	// the watched paths' repositories to be ignored.
+	gitignore bool
+
+	// recursive causes every unignored subdirectory of the input
+	// directories to be watched, including the ones created after the
+	// watch began.
+	recursive bool
+
+	// ops are the fsnotify ops that cause a run, optionally for the
+	// paths matching a pattern, like "create,write" or
+	// "create,remove:*.lock". Every op does without them.
+	ops []string
+
+	// changedFile, if not empty, is the file the rule writes the
+	// changed paths to before each run. It is ignored, or every run
+	// would cause another.
+	changedFile string
+
+	// name is the name of the rule the paths are watched for, if there
+	// is more than one.
+	name string
+}
+
+// watch watches the paths in wc. The returned watcher should only be used in
 // tests.
-func watch(inputPaths, ignoredPaths []string, cmdCh chan<- event) (*fsnotify.Watcher, error) {
+func watch(wc watchConfig, cmdCh chan<- event) (*watcher, error) {
+	w, err := newWatcher(watcherOptions{})
+	if err != nil {
+		return nil, err
+	}
+	err = w.addSet(wc, cmdCh)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	w.start()
+	return w, nil
+}
+
+// watcherOptions are the settings of a watcher's backend.
+type watcherOptions struct {
+	// poll, if not zero, causes the paths to be polled for changes at
+	// that interval instead of the OS telling justrun about them.
+	poll time.Duration
+	// pollFallback, if not zero, causes the paths the OS runs out of
+	// watches for to be polled at that interval.
+	pollFallback time.Duration
+	// buffer is the number of events the OS has reported that may wait
+	// for justrun to handle them.
+	buffer uint
+	// hash causes writes that leave a file's contents the same to be
+	// ignored.
+	hash bool
+	// log is where messages about events and watch errors go. If it is
+	// nil, they are dropped.
+	log *slog.Logger
+	// lifecycle, if not nil, is where records of the watches and events
+	// are written.
+	lifecycle *lifecycleLog
+}
+
+// watcher wraps a backend and sends the events from it to the watchSets
+// that don't ignore them. It keeps track of the directories it registered
+// on behalf of recursive watches.
+type watcher struct {
+	fs backend
+	// log is where messages about events and watch errors go. Events
+	// are logged at the debug level.
+	log *slog.Logger
+	// lifecycle is where records of the watches and events go.
+	lifecycle *lifecycleLog
+	// git is nil unless a watchSet ignores the paths ignored by git.
+	git *gitIgnorer
+	// hashes is nil unless writes that leave a file the same are
+	// ignored.
+	hashes *contentHashes
+
+	mu   sync.Mutex
+	sets []*watchSet
+	// dirs is the set of directories inside of the watchSets' roots
+	// (including the roots themselves) that are currently watched.
+	dirs map[string]bool
+}
+
+// watchSet is the part of a watcher created from a single watchConfig.
+type watchSet struct {
+	ignorer *smartIgnorer
+	// roots are the directories that are watched recursively, either
+	// because the user asked for it or because a glob pattern may
+	// match paths in their subdirectories.
+	roots []string
+	name  string
+	log   *slog.Logger
+	cmdCh chan<- event
+	// queue sends the events to cmdCh. It is shared with the watchSets
+	// that replace this one.
+	queue *eventQueue
+	// ops decide which fsnotify ops cause a run.
+	ops []*opsRule
+	// watched are the paths the watchSet itself added to the backend,
+	// as opposed to the directories addTree added in its roots.
+	watched []string
+}
+
+func newWatcher(opts watcherOptions) (*watcher, error) {
+	log := opts.log
+	if log == nil {
+		log = slog.New(slog.DiscardHandler)
+	}
+	var b backend
+	switch {
+	case opts.poll > 0:
+		b = newPollBackend(opts.poll)
+	case opts.pollFallback > 0:
+		nb, err := newNotifyBackend(opts.buffer)
+		if err != nil {
+			if !isLimit(err) {
+				return nil, fmt.Errorf("unable to create watcher: %s", err)
+			}
+			log.Warn("unable to watch with the OS, polling every path for changes", "err", explainLimit(err))
+			b = newFallbackBackend(nil, opts.pollFallback, log)
+		} else {
+			b = newFallbackBackend(nb, opts.pollFallback, log)
+		}
+	default:
+		nb, err := newNotifyBackend(opts.buffer)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create watcher: %s", explainLimit(err))
+		}
+		b = nb
+	}
+	w := &watcher{
+		fs:        b,
+		log:       log,
+		lifecycle: opts.lifecycle,
+		dirs:      make(map[string]bool),
+	}
+	if opts.hash {
+		w.hashes = newContentHashes()
+	}
+	return w, nil
+}
+
+// start begins sending events to the watchSets. The watchSets' channels
+// will be closed when the watcher is, once the events queued for them
+// have been taken.
+func (w *watcher) start() {
+	if w.hashes != nil {
+		w.seedHashes()
+	}
+	go w.listenForEvents()
+}
+
+// seedHashes records the hashes of the contents of the watched files that
+// are not ignored by every watchSet. The files created later are recorded
+// as their events arrive.
+func (w *watcher) seedHashes() {
+	w.mu.Lock()
+	sets := w.sets
+	paths := make([]string, 0, len(w.dirs))
+	for path := range w.dirs {
+		paths = append(paths, path)
+	}
+	for _, set := range sets {
+		paths = append(paths, set.watched...)
+	}
+	w.mu.Unlock()
+	wanted := func(path string) bool {
+		for _, set := range sets {
+			if !set.ignorer.IsIgnored(path) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, path := range paths {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			// It's a file, or gone.
+			if wanted(path) {
+				w.hashes.record(path)
+			}
+			continue
+		}
+		for _, e := range entries {
+			p := filepath.Join(path, e.Name())
+			if e.Type().IsRegular() && wanted(p) {
+				w.hashes.record(p)
+			}
+		}
+	}
+}
+
+// addSet watches the paths in wc and sends the events for them that are
+// not ignored to cmdCh.
+func (w *watcher) addSet(wc watchConfig, cmdCh chan<- event) error {
+	set, err := w.newSet(wc, cmdCh)
+	if err != nil {
+		return err
+	}
+	set.queue = newEventQueue(cmdCh)
+	w.mu.Lock()
+	w.sets = append(w.sets, set)
+	w.mu.Unlock()
+	return nil
+}
+
+// updateSet replaces the watchSet that sends events to cmdCh with one
+// that watches the paths in wc. The paths only the old watchSet needed
+// stop being watched.
+func (w *watcher) updateSet(wc watchConfig, cmdCh chan<- event) error {
+	set, err := w.newSet(wc, cmdCh)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var old *watchSet
+	// listenForEvents may still be using the current slice, so a new
+	// one is made.
+	sets := make([]*watchSet, len(w.sets))
+	for i, s := range w.sets {
+		if s.cmdCh == cmdCh {
+			old = s
+			s = set
+		}
+		sets[i] = s
+	}
+	if old == nil {
+		return errors.New("no watched paths to update")
+	}
+	set.queue = old.queue
+	w.sets = sets
+	w.release(old, sets)
+	return nil
+}
+
+// release stops watching the paths that old watched and none of the
+// watchSets in sets do. It must be called with w.mu held.
+func (w *watcher) release(old *watchSet, sets []*watchSet) {
+	inUse := make(map[string]bool)
+	for _, s := range sets {
+		for _, path := range s.watched {
+			inUse[path] = true
+		}
+	}
+	for _, path := range old.watched {
+		if !inUse[path] && !w.dirs[path] {
+			w.fs.Remove(path)
+		}
+	}
+	for path := range w.dirs {
+		if !old.underRoot(path) || inUse[path] {
+			continue
+		}
+		stillUnder := false
+		for _, s := range sets {
+			if s.underRoot(path) {
+				stillUnder = true
+				break
+			}
+		}
+		if !stillUnder {
+			w.fs.Remove(path)
+			delete(w.dirs, path)
+		}
+	}
+}
+
+// newSet creates a watchSet for wc and adds its paths to the backend. If it
+// fails, the paths it added are removed again.
+func (w *watcher) newSet(wc watchConfig, cmdCh chan<- event) (_ *watchSet, err error) {
+	var set *watchSet
+	defer func() {
+		if err != nil && set != nil {
+			w.mu.Lock()
+			w.release(set, w.sets)
+			w.mu.Unlock()
+		}
+	}()
 	// Creates an Ignorer that just ignores file paths the user
-	// specifically asked to be ignored.
+	// specifically asked to be ignored, and the files justrun writes.
+	ignoredPaths := wc.ignoredPaths[:len(wc.ignoredPaths):len(wc.ignoredPaths)]
+	if own := w.lifecycle.ownFile(); own != "" {
+		ignoredPaths = append(ignoredPaths, own)
+	}
+	if wc.changedFile != "" {
+		ignoredPaths = append(ignoredPaths, wc.changedFile)
+	}
 	ui, err := createUserIgnorer(ignoredPaths)
 	if err != nil {
 		return nil, err
 	}
-
-	w, err := fsnotify.NewWatcher()
+	base := wc.patternBase
+	if base == "" {
+		base, err = os.Getwd()
+		if err != nil {
+			return nil, errors.New("unable to get current working dir while working with ignore patterns")
+		}
+	}
+	pi, err := createPatternIgnorer(base, wc.ignorePatterns)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create watcher: %s", err)
+		return nil, err
+	}
+	ignorers := multiIgnorer{ui, pi}
+	set = &watchSet{name: wc.name, log: w.log, cmdCh: cmdCh}
+	if wc.name != "" {
+		set.log = w.log.With("rule", wc.name)
+	}
+	for _, s := range wc.ops {
+		or, err := parseOpsRule(base, s)
+		if err != nil {
+			return nil, err
+		}
+		set.ops = append(set.ops, or)
 	}
 
 	// Watch user-specified paths and create a set of them for walking
 	// later. Paths that are both asked to be watched and ignored by
-	// the user are ignored.
+	// the user are ignored. Paths containing glob metacharacters are
+	// set aside to be watched from their base directories.
 	userPaths := make(map[string]bool)
 	includedHiddenFiles := make(map[string]bool)
-	for _, path := range inputPaths {
+	var globs []*globPattern
+	for _, path := range wc.inputPaths {
+		if hasMeta(path) {
+			g, err := newGlobPattern(path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse glob pattern '%s': %s", path, err)
+			}
+			globs = append(globs, g)
+			continue
+		}
 		fullPath, err := filepath.Abs(path)
 		if err != nil {
-			w.Close()
 			return nil, errors.New("unable to get current working directory while working with user-watched paths")
 		}
-		if userPaths[fullPath] || ui.IsIgnored(path) {
+		if userPaths[fullPath] || ignorers.IsIgnored(fullPath) {
 			continue
 		}
-		err = w.Add(fullPath)
+		err = w.watchFor(set, fullPath)
 		if err != nil {
-			w.Close()
 			return nil, fmt.Errorf("unable to watch '%s': %s", path, err)
 		}
 		userPaths[fullPath] = true
 	}
 
+	// In recursive mode, every user-specified directory is the root of
+	// a tree of directories to watch. Glob patterns that can match
+	// paths below their base directory need the same treatment.
+	var recursiveRoots []string
+	if wc.recursive {
+		for fullPath := range userPaths {
+			fi, err := os.Stat(fullPath)
+			if err == nil && fi.IsDir() {
+				recursiveRoots = append(recursiveRoots, fullPath)
+			}
+		}
+	}
+	set.roots = append(set.roots, recursiveRoots...)
+	for _, g := range globs {
+		if g.recursive {
+			set.roots = append(set.roots, g.base)
+			continue
+		}
+		err = w.watchFor(set, g.base)
+		if err != nil {
+			return nil, fmt.Errorf("unable to watch '%s' for glob pattern '%s': %s", g.base, g.pattern, err)
+		}
+	}
+
 	// Create some useful sets from the user-specified paths to be
 	// used in smartIgnorer (and, therefore, listenForEvents). One is
 	// the set of hidden paths that the user does not want ignored to
 	// be used in smartIgnorer. We create this smaller map because the
 	// amount of paths the user asked to watch may be large.
 	//
-	// We also create the sets renameDirs and renameChildren to better
-	// handle files that are renamed away and back from the paths the
-	// user wanted watched. To be more concrete, folks might want to
-	// watch only the normal file "foobar" but their tooling moves
-	// foobar away and then back (like vim does on save). This will
-	// cause the watch to fire on the first move but then never again,
-	// even when its returned. So, we have to track the parent
+	// We also watch the parent directories of the user's paths to
+	// better handle files that are renamed away and back from the
+	// paths the user wanted watched. To be more concrete, folks might
+	// want to watch only the normal file "foobar" but their tooling
+	// moves foobar away and then back (like vim does on save). This
+	// will cause the watch to fire on the first move but then never
+	// again, even when its returned. So, we have to watch the parent
 	// directory of foobar in order to capture when foobar shows up in
-	// its parent directory again but we don't want to send all events
-	// in that parent directory.
+	// its parent directory again. The smartIgnorer makes sure we don't
+	// send the other events in that parent directory. Directories
+	// inside of a recursively watched tree are already watched in
+	// full, so they are skipped.
 	renameDirs := make(map[string]bool)
-	renameChildren := make(map[string]bool)
 	for fullPath, _ := range userPaths {
 		baseName := filepath.Base(fullPath)
 		if strings.HasPrefix(baseName, ".") {
@@ -73,27 +425,190 @@ func watch(inputPaths, ignoredPaths []string, cmdCh chan<- event) (*fsnotify.Wat
 		}
 
 		dirPath := filepath.Dir(fullPath)
-		if !userPaths[dirPath] && dirPath != "" {
-			if !renameDirs[dirPath] {
-				err = w.Add(dirPath)
+		if !userPaths[dirPath] && dirPath != "" && !set.underRoot(dirPath) && !renameDirs[dirPath] {
+			err = w.watchFor(set, dirPath)
+			if err != nil {
+				return nil, fmt.Errorf("unable to watch rename-watched-only dir '%s': %s", fullPath, err)
+			}
+			renameDirs[dirPath] = true
+		}
+	}
+
+	// Ignoring what git ignores requires reading the .gitignore files
+	// from the watched paths up to their repository roots. Their
+	// directories are watched so that changes to them take effect
+	// immediately.
+	if wc.gitignore {
+		w.mu.Lock()
+		if w.git == nil {
+			w.git = newGitIgnorer()
+		}
+		w.mu.Unlock()
+		bases := make([]string, 0, len(userPaths)+len(globs))
+		for fullPath := range userPaths {
+			bases = append(bases, fullPath)
+		}
+		for _, g := range globs {
+			bases = append(bases, g.base)
+		}
+		for _, base := range bases {
+			for _, dir := range w.git.addRepoFor(base) {
+				err = w.watchFor(set, dir)
 				if err != nil {
-					w.Close()
-					return nil, fmt.Errorf("unable to watch rename-watched-only dir '%s': %s", fullPath, err)
+					return nil, fmt.Errorf("unable to watch '%s' for changes to .gitignore files: %s", dir, err)
 				}
 			}
-			renameDirs[dirPath] = true
-			renameChildren[fullPath] = true
 		}
+		ignorers = append(ignorers, w.git)
 	}
-	ig := &smartIgnorer{
+
+	set.ignorer = &smartIgnorer{
 		includedHiddenFiles: includedHiddenFiles,
-		ui:                  ui,
-		renameDirs:          renameDirs,
-		renameChildren:      renameChildren,
+		ui:                  ignorers,
+		userPaths:           userPaths,
+		recursiveRoots:      recursiveRoots,
+		globs:               globs,
 	}
 
-	go listenForEvents(w, cmdCh, ig)
-	return w, nil
+	for _, root := range set.roots {
+		err = w.addTree(set, root, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to watch subdirectories of '%s': %s", root, err)
+		}
+	}
+
+	return set, nil
+}
+
+// watchFor adds path to the backend on behalf of set.
+func (w *watcher) watchFor(set *watchSet, path string) error {
+	err := w.fs.Add(path)
+	if err != nil {
+		return explainLimit(err)
+	}
+	set.watched = append(set.watched, path)
+	w.lifecycle.emit(lifecycleRecord{Type: recWatch, Rule: set.name, Path: path})
+	return nil
+}
+
+// backendCounts returns the number of paths the OS watches and the number
+// polled because it ran out of watches, if the watcher falls back to
+// polling.
+func (w *watcher) backendCounts() (native, polled int, ok bool) {
+	fb, ok := w.fs.(*fallbackBackend)
+	if !ok {
+		return 0, 0, false
+	}
+	native, polled = fb.counts()
+	return native, polled, true
+}
+
+// Close stops the watcher. The event channels of its watchSets will be
+// closed shortly after.
+func (w *watcher) Close() error {
+	return w.fs.Close()
+}
+
+// underRoot returns true if path is one of the recursively watched roots or
+// is inside of one of them.
+func (set *watchSet) underRoot(path string) bool {
+	for _, root := range set.roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTree watches dir and all of its subdirectories that are not ignored by
+// the watchSet. Errors for subdirectories that disappear while being walked
+// are ignored. If found is non-nil, it is called with every file path seen
+// in the walk.
+func (w *watcher) addTree(set *watchSet, dir string, found func(path string)) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			if found != nil {
+				found(path)
+			}
+			return nil
+		}
+		if path != dir && set.ignorer.canSkipDir(path) {
+			return filepath.SkipDir
+		}
+		return w.addDir(path)
+	})
+}
+
+func (w *watcher) addDir(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.dirs[path] {
+		return nil
+	}
+	err := w.fs.Add(path)
+	if err != nil {
+		return explainLimit(err)
+	}
+	w.dirs[path] = true
+	w.lifecycle.emit(lifecycleRecord{Type: recWatch, Path: path})
+	return nil
+}
+
+// removeTree forgets about dir and all of the watched directories inside of
+// it. Removed directories lose their inotify watches on their own, but
+// renamed ones do not, so the watches are removed explicitly.
+func (w *watcher) removeTree(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prefix := dir + string(filepath.Separator)
+	for path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			w.fs.Remove(path)
+			delete(w.dirs, path)
+		}
+	}
+}
+
+// updateTree adds and removes recursive watches in response to directories
+// being created, removed, or renamed inside of the watchSets' roots. Files
+// that were created in a new directory before its watch was added would
+// never have their own events, so Create events for them are returned.
+func (w *watcher) updateTree(sets []*watchSet, ev fsnotify.Event) []fsnotify.Event {
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		w.removeTree(ev.Name)
+		return nil
+	}
+	if !ev.Has(fsnotify.Create) {
+		return nil
+	}
+	var missed []fsnotify.Event
+	seen := make(map[string]bool)
+	for _, set := range sets {
+		if !set.underRoot(ev.Name) || set.ignorer.canSkipDir(ev.Name) {
+			continue
+		}
+		fi, err := os.Stat(ev.Name)
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		err = w.addTree(set, ev.Name, func(path string) {
+			if !seen[path] {
+				seen[path] = true
+				missed = append(missed, fsnotify.Event{Name: path, Op: fsnotify.Create})
+			}
+		})
+		if err != nil {
+			w.log.Warn("unable to watch new directory", "path", ev.Name, "err", err)
+			w.lifecycle.emit(lifecycleRecord{Type: recWatchError, Path: ev.Name, Error: err.Error()})
+		}
+	}
+	return missed
 }
 
 type event struct {
@@ -101,38 +616,164 @@ type event struct {
 	Event fsnotify.Event
 }
 
-func listenForEvents(w *fsnotify.Watcher, cmdCh chan<- event, ignorer Ignorer) {
+// lost returns true if ev stands for changes to unknown paths, after the OS
+// dropped the events for them. Lost events have no path.
+func (ev event) lost() bool {
+	return ev.Event.Name == ""
+}
+
+func (w *watcher) listenForEvents() {
 	for {
 		select {
-		case ev, ok := <-w.Events:
+		case ev, ok := <-w.fs.Events():
 			if !ok {
+				w.closeSets()
 				return
 			}
-			if ignorer.IsIgnored(ev.Name) {
-				continue
-			}
-			if *verbose {
-				log.Printf("unignored file change: %s", ev)
+			if w.git != nil {
+				w.git.invalidate(ev.Name)
 			}
-			cmdCh <- event{
-				Time:  time.Now(),
-				Event: ev,
+			w.mu.Lock()
+			sets := w.sets
+			w.mu.Unlock()
+			evs := append([]fsnotify.Event{ev}, w.updateTree(sets, ev)...)
+			for _, ev := range evs {
+				unchanged := w.contentCheck(ev)
+				for _, set := range sets {
+					if set.ignorer.IsIgnored(ev.Name) {
+						w.ignored(set, ev, "")
+						continue
+					}
+					if !wantsOp(set.ops, ev) {
+						w.ignored(set, ev, "op filtered")
+						continue
+					}
+					if unchanged() {
+						w.ignored(set, ev, "contents unchanged")
+						continue
+					}
+					set.log.Debug("file change", "path", ev.Name, "op", ev.Op)
+					w.lifecycle.emit(lifecycleRecord{Type: recEventUsed, Rule: set.name, Path: ev.Name, Op: ev.Op.String()})
+					set.queue.send(event{
+						Time:  time.Now(),
+						Event: ev,
+					})
+				}
 			}
-		case err, ok := <-w.Errors:
+		case err, ok := <-w.fs.Errors():
 			if !ok {
-				close(cmdCh)
+				w.closeSets()
 				return
 			}
 			// w.Close causes this.
 			if err == nil {
-				close(cmdCh)
+				w.closeSets()
 				return
 			}
-			log.Println("watch error:", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.overflowed()
+				continue
+			}
+			w.log.Warn("watch error", "err", err)
+			w.lifecycle.emit(lifecycleRecord{Type: recWatchError, Error: err.Error()})
 		}
 	}
 }
 
+// overflowed handles the OS dropping events because too many happened at
+// once. Since anything may have changed, every watchSet is sent a lost
+// event, after the directories created inside of recursive watches in the
+// meantime are watched and the hashes are redone for the current contents
+// of the files.
+func (w *watcher) overflowed() {
+	w.log.Warn("the OS dropped fs events because too many happened at once, running the commands in case they matter. Raising fs.inotify.max_queued_events or -event-buffer may prevent this")
+	w.lifecycle.emit(lifecycleRecord{Type: recEventsLost})
+	w.mu.Lock()
+	sets := w.sets
+	w.mu.Unlock()
+	w.rescan(sets)
+	if w.hashes != nil {
+		w.hashes.reset()
+		w.seedHashes()
+	}
+	for _, set := range sets {
+		set.queue.send(event{Time: time.Now()})
+	}
+}
+
+// rescan watches the directories in the watchSets' roots that are not
+// watched yet, and forgets the ones that are gone.
+func (w *watcher) rescan(sets []*watchSet) {
+	w.mu.Lock()
+	for path := range w.dirs {
+		if _, err := os.Stat(path); err != nil {
+			w.fs.Remove(path)
+			delete(w.dirs, path)
+		}
+	}
+	w.mu.Unlock()
+	for _, set := range sets {
+		for _, root := range set.roots {
+			err := w.addTree(set, root, nil)
+			if err != nil {
+				w.log.Warn("unable to rescan directory", "path", root, "err", err)
+				w.lifecycle.emit(lifecycleRecord{Type: recWatchError, Path: root, Error: err.Error()})
+			}
+		}
+	}
+}
+
+// contentCheck returns a func that reports whether ev left the file's
+// contents the same, if those are ignored. That includes creates, since
+// editors often save by renaming a new file over the old one. The func is
+// only called for events a watchSet didn't ignore, and hashes the file the
+// first time it's called, so that ignored files are never read.
+func (w *watcher) contentCheck(ev fsnotify.Event) func() bool {
+	if w.hashes == nil {
+		return func() bool { return false }
+	}
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		w.hashes.forget(ev.Name)
+		return func() bool { return false }
+	}
+	checked, unchanged := false, false
+	return func() bool {
+		if checked {
+			return unchanged
+		}
+		checked = true
+		unchanged = w.hashes.unchanged(ev.Name)
+		return unchanged
+	}
+}
+
+// ignored records that set ignored ev, and why, if anything is listening.
+// If reason is empty, the set's ignorer is asked for it.
+func (w *watcher) ignored(set *watchSet, ev fsnotify.Event, reason string) {
+	debug := set.log.Enabled(context.Background(), slog.LevelDebug)
+	if (w.lifecycle == nil || ev.Name == w.lifecycle.ownFile()) && !debug {
+		return
+	}
+	if reason == "" {
+		reason = set.ignorer.ignoreReason(ev.Name)
+	}
+	if debug {
+		set.log.Debug("ignored file change", "path", ev.Name, "op", ev.Op, "reason", reason)
+	}
+	if w.lifecycle != nil && ev.Name != w.lifecycle.ownFile() {
+		w.lifecycle.emit(lifecycleRecord{Type: recEventIgnore, Rule: set.name, Path: ev.Name, Op: ev.Op.String(), Reason: reason})
+	}
+}
+
+func (w *watcher) closeSets() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, set := range w.sets {
+		set.queue.close()
+	}
+	w.sets = nil
+}
+
 func createUserIgnorer(ignoredPaths []string) (*userIgnorer, error) {
 	ignored := make(map[string]bool)
 	ignoredDirs := make([]string, 0)